@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// A file as it was before a chunked apply touched it
+type fileBackup struct {
+	content []byte
+	existed bool
+}
+
+// Applies a large batch APPLY_CHUNK_SIZE actions at a time, reporting
+// "actions_applied" of "actions_total" to /api/progress after each chunk.
+// Every file is backed up before its chunk runs, so when a chunk fails
+// with an error the files written by earlier chunks are restored too and
+// the project is left as it was. Duplicate creates are resolved across the
+// whole batch first, as applyEditActions would.
+func applyInChunks(ctx context.Context, edits AIEditActions, opts ApplyOptions, size int) (*ApplyResult, error) {
+	progress := progressFrom(ctx)
+	result := &ApplyResult{}
+
+	duplicates, conflicting := duplicateCreates(edits.Actions)
+	result.DuplicateCreates = conflicting
+	var actions []EditAction
+	for i, act := range edits.Actions {
+		if reason, ok := duplicates[i]; ok {
+			path := actionPath(act.Path)
+			log.Printf("Skipping %s %s: %s", act.Type, path, reason)
+			result.Skipped = append(result.Skipped, SkippedAction{Type: act.Type, Path: path, Reason: reason})
+			continue
+		}
+		actions = append(actions, act)
+	}
+
+	total := len(edits.Actions)
+	done := total - len(actions)
+	progress.setActions(done, total)
+
+	backups := map[string]fileBackup{} // full path -> state before the apply
+	for start := 0; start < len(actions); start += size {
+		end := start + size
+		if end > len(actions) {
+			end = len(actions)
+		}
+		chunk := actions[start:end]
+		for _, act := range chunk {
+			fullPath := opts.filePath(actionPath(act.Path))
+			if _, ok := backups[fullPath]; ok {
+				continue
+			}
+			content, err := ioutil.ReadFile(fullPath)
+			switch {
+			case err == nil:
+				backups[fullPath] = fileBackup{content: content, existed: true}
+			case os.IsNotExist(err):
+				backups[fullPath] = fileBackup{}
+			}
+		}
+
+		log.Printf("Applying actions %d-%d of %d", start+1, end, len(actions))
+		partial, err := applyEditActions(AIEditActions{Actions: chunk}, opts)
+		result.merge(partial)
+		if err != nil {
+			restored := restoreBackups(backups)
+			log.Printf("Chunked apply failed at actions %d-%d, restored %d files", start+1, end, restored)
+			return result, fmt.Errorf("applying actions %d-%d of %d: %w (%d files written by this batch were restored)", start+1, end, len(actions), err, restored)
+		}
+		if partial != nil {
+			progress.addApplied(partial.Changed)
+		}
+		progress.setActions(done+end, total)
+	}
+	return result, nil
+}
+
+// Puts every backed up file back as it was, removing the ones that didn't
+// exist. Returns how many files were changed back.
+func restoreBackups(backups map[string]fileBackup) int {
+	restored := 0
+	for fullPath, backup := range backups {
+		current, err := ioutil.ReadFile(fullPath)
+		exists := err == nil
+		if !backup.existed {
+			if exists {
+				if err := os.Remove(fullPath); err != nil {
+					log.Printf("Failed to remove %s: %v", fullPath, err)
+					continue
+				}
+				restored++
+			}
+			continue
+		}
+		if exists && string(current) == string(backup.content) {
+			continue
+		}
+		if err := ioutil.WriteFile(fullPath, backup.content, 0644); err != nil {
+			log.Printf("Failed to restore %s: %v", fullPath, err)
+			continue
+		}
+		restored++
+	}
+	return restored
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Limits for /api/import: the uploaded zip (IMPORT_MAX_BYTES), its
+// extracted size (IMPORT_MAX_UNCOMPRESSED_BYTES) and its number of files
+// (IMPORT_MAX_FILES)
+const (
+	defaultImportMaxBytes             = 50 << 20
+	defaultImportMaxUncompressedBytes = 200 << 20
+	defaultImportMaxFiles             = 5000
+)
+
+// Streams the context-eligible project files as a zip of src/
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+
+	var paths []string
+	types := contextExtensions()
+	err := filepath.WalkDir(projectRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && matchesFileType(path, types) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="project.zip"`)
+
+	// Headers are sent with the first write, so failures from here on can
+	// only be logged and leave the client with a truncated archive
+	archive := zip.NewWriter(w)
+	for _, path := range paths {
+		rel, err := filepath.Rel(projectRoot, path)
+		if err != nil {
+			log.Printf("Export failed: %v", err)
+			return
+		}
+		if err := addFileToZip(archive, path, "src/"+filepath.ToSlash(rel)); err != nil {
+			log.Printf("Export failed at %s: %v", path, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Export failed: %v", err)
+	}
+}
+
+// Copies a file on disk into the archive under the given name
+func addFileToZip(archive *zip.Writer, path, name string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	header.Method = zip.Deflate
+
+	dst, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, file)
+	return err
+}
+
+// Extracts an uploaded zip (the request body) into the project's src/.
+// Archives from /api/export can be imported as-is; their src/ prefix is
+// stripped. With ?clear=true the existing files are removed first. The
+// body must be sent as application/zip: browsers can't send that type
+// cross-origin without a preflight, which this endpoint never approves.
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/zip" {
+		writeError(w, http.StatusUnsupportedMediaType, errCodeInvalidRequest, "Send the archive with Content-Type: application/zip", nil)
+		return
+	}
+
+	limit := int64(envInt("IMPORT_MAX_BYTES", defaultImportMaxBytes))
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if int64(len(data)) > limit {
+		writeError(w, http.StatusRequestEntityTooLarge, errCodeTooLarge, fmt.Sprintf("archive is over the %d byte limit", limit), nil)
+		return
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "body is not a zip archive: "+err.Error(), nil)
+		return
+	}
+
+	// Validate every entry before touching the project, so a single unsafe
+	// path rejects the whole archive
+	entries, err := importEntries(archive)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errCodePathRejected, err.Error(), nil)
+		return
+	}
+	// A small zip can expand to fill the disk, so the sizes the archive
+	// declares are checked up front and enforced while extracting
+	if err := checkImportSize(entries); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("clear") == "true" {
+		if err := clearProjectFiles(); err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+			return
+		}
+	}
+
+	imported := []string{}
+	var skipped []SkippedAction
+	for _, entry := range entries {
+		if strings.Contains(entry.path, "SidePanel") {
+			skipped = append(skipped, SkippedAction{Type: "import", Path: entry.path, Reason: "SidePanel.tsx is protected"})
+			continue
+		}
+		if err := extractZipFile(entry.file, projectFilePath(entry.path)); err != nil {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), map[string]interface{}{"imported": imported})
+			return
+		}
+		imported = append(imported, entry.path)
+	}
+	log.Printf("Imported %d files from zip", len(imported))
+
+	response := map[string]interface{}{
+		"status":   "success",
+		"imported": imported,
+	}
+	if len(skipped) > 0 {
+		response["skipped"] = skipped
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// A zip entry and the "src/..." path it will be written to
+type importEntry struct {
+	file *zip.File
+	path string
+}
+
+// Maps the regular files in an archive to project paths, rejecting any
+// entry that could end up outside src/ (zip-slip) or can't be written
+func importEntries(archive *zip.Reader) ([]importEntry, error) {
+	// Strip a shared top-level src/ folder, as written by /api/export
+	stripSrc := true
+	for _, f := range archive.File {
+		if !strings.HasPrefix(f.Name, "src/") {
+			stripSrc = false
+			break
+		}
+	}
+
+	var entries []importEntry
+	for _, f := range archive.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			return nil, fmt.Errorf("entry %q is not a regular file", f.Name)
+		}
+
+		name := f.Name
+		if strings.Contains(name, "\\") || strings.HasPrefix(name, "/") || strings.Contains(name, ":") {
+			return nil, fmt.Errorf("entry %q has an unsafe path", f.Name)
+		}
+		for _, segment := range strings.Split(name, "/") {
+			if segment == ".." {
+				return nil, fmt.Errorf("entry %q has an unsafe path", f.Name)
+			}
+		}
+		name = path.Clean(name)
+		if stripSrc {
+			name = strings.TrimPrefix(name, "src/")
+		}
+		if name == "." || name == "" {
+			continue
+		}
+
+		projectPath := "src/" + name
+		if reason := pathRejection(projectPath); reason != "" {
+			return nil, fmt.Errorf("entry %q: %s", f.Name, reason)
+		}
+		entries = append(entries, importEntry{file: f, path: projectPath})
+	}
+	return entries, nil
+}
+
+// Rejects archives with more files or more extracted bytes than the
+// IMPORT_MAX_FILES and IMPORT_MAX_UNCOMPRESSED_BYTES limits allow
+func checkImportSize(entries []importEntry) error {
+	if limit := envInt("IMPORT_MAX_FILES", defaultImportMaxFiles); limit > 0 && len(entries) > limit {
+		return newAPIError(http.StatusRequestEntityTooLarge, errCodeTooLarge, fmt.Sprintf("archive has %d files, over the %d file limit", len(entries), limit), nil)
+	}
+	limit := uint64(envInt("IMPORT_MAX_UNCOMPRESSED_BYTES", defaultImportMaxUncompressedBytes))
+	var total uint64
+	for _, entry := range entries {
+		total += entry.file.UncompressedSize64
+		if limit > 0 && total > limit {
+			return newAPIError(http.StatusRequestEntityTooLarge, errCodeTooLarge, fmt.Sprintf("archive extracts to more than the %d byte limit", limit), nil)
+		}
+	}
+	return nil
+}
+
+// Writes a zip entry to disk, creating parent directories as needed. No
+// more than the size the entry declares is written.
+func extractZipFile(f *zip.File, fullPath string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return err
+	}
+	dst, err := os.Create(fullPath)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(dst, io.LimitReader(src, int64(f.UncompressedSize64)+1))
+	if err == nil && uint64(n) > f.UncompressedSize64 {
+		err = fmt.Errorf("entry %q is larger than its declared size", f.Name)
+	}
+	if err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// Removes every file under src/ except the protected side panel, then any
+// directories left empty
+func clearProjectFiles() error {
+	err := filepath.WalkDir(projectRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.Contains(p, "SidePanel") {
+			return nil
+		}
+		return os.Remove(p)
+	})
+	if err != nil {
+		return err
+	}
+	removeEmptyDirs(projectRoot)
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Builds a zip holding files, keyed by entry name
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestImportChecks(t *testing.T) {
+	// Compresses to a few KB
+	large := strings.Repeat("0", 4<<20)
+	tests := []struct {
+		name    string
+		env     map[string]string
+		headers map[string]string
+		files   map[string]string
+		status  int
+		code    string
+	}{
+		{
+			name:   "within the limits",
+			files:  map[string]string{"src/a.ts": "export const a = 1;\n", "src/b.ts": "export const b = 2;\n"},
+			status: http.StatusOK,
+		},
+		{
+			name:   "too many files",
+			env:    map[string]string{"IMPORT_MAX_FILES": "1"},
+			files:  map[string]string{"src/a.ts": "export const a = 1;\n", "src/b.ts": "export const b = 2;\n"},
+			status: http.StatusRequestEntityTooLarge,
+			code:   errCodeTooLarge,
+		},
+		{
+			name:   "extracts to too many bytes",
+			env:    map[string]string{"IMPORT_MAX_UNCOMPRESSED_BYTES": "1048576"},
+			files:  map[string]string{"src/a.ts": "export const a = 1;\n", "src/big.ts": large},
+			status: http.StatusRequestEntityTooLarge,
+			code:   errCodeTooLarge,
+		},
+		{
+			name:    "without the zip content type",
+			headers: map[string]string{"Content-Type": "text/plain"},
+			files:   map[string]string{"src/a.ts": "export const a = 1;\n"},
+			status:  http.StatusUnsupportedMediaType,
+			code:    errCodeInvalidRequest,
+		},
+		{
+			name:    "from another website",
+			headers: map[string]string{"Origin": "https://evil.example"},
+			files:   map[string]string{"src/a.ts": "export const a = 1;\n"},
+			status:  http.StatusForbidden,
+			code:    errCodeForbidden,
+		},
+		{
+			name:   "unsafe path",
+			files:  map[string]string{"../evil.ts": "export {};\n"},
+			status: http.StatusBadRequest,
+			code:   errCodePathRejected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			root := useTempProject(t, map[string]string{"App.tsx": "export default function App() { return null; }\n"})
+			before := readTree(t, root)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/import?clear=true", bytes.NewReader(zipArchive(t, tt.files)))
+			req.Header.Set("Content-Type", "application/zip")
+			for key, value := range tt.headers {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			withSameOrigin(handleImport)(rec, req)
+
+			if origin := rec.Header().Get("Access-Control-Allow-Origin"); origin != "" {
+				t.Errorf("Access-Control-Allow-Origin = %q, want none", origin)
+			}
+
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d; body %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.code == "" {
+				return
+			}
+			var body struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			json.NewDecoder(rec.Body).Decode(&body)
+			if body.Error.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Error.Code, tt.code)
+			}
+			if got := readTree(t, root); !reflect.DeepEqual(got, before) {
+				t.Errorf("rejected import changed the project: %q, want %q", got, before)
+			}
+		})
+	}
+}
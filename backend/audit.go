@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Durable record of one applied edit batch, written to AUDIT_DIR
+type AuditManifest struct {
+	Timestamp    time.Time     `json:"timestamp"`
+	Endpoint     string        `json:"endpoint"`
+	Instructions string        `json:"instructions,omitempty"`
+	Provider     string        `json:"provider,omitempty"`
+	Model        string        `json:"model,omitempty"`
+	Actions      []AuditedEdit `json:"actions"`
+	Result       *ApplyResult  `json:"result"`
+}
+
+// An action as recorded in the manifest: the content is replaced by its hash
+type AuditedEdit struct {
+	Type        string `json:"type"`
+	Path        string `json:"path"`
+	ContentHash string `json:"content_hash,omitempty"`
+	Bytes       int    `json:"bytes,omitempty"`
+}
+
+// Writes a manifest for an applied batch when AUDIT_DIR is set. Failures are
+// logged rather than returned, since the edits have already been applied.
+func writeAuditManifest(endpoint string, req EditRequest, actions []EditAction, result *ApplyResult) {
+	dir := os.Getenv("AUDIT_DIR")
+	if dir == "" || result == nil {
+		return
+	}
+
+	manifest := AuditManifest{
+		Timestamp:    time.Now().UTC(),
+		Endpoint:     endpoint,
+		Instructions: req.Instructions,
+		Provider:     req.Provider,
+		Model:        req.Model,
+		Actions:      make([]AuditedEdit, 0, len(actions)),
+		Result:       result,
+	}
+	for _, act := range actions {
+		audited := AuditedEdit{Type: act.Type, Path: actionPath(act.Path)}
+		if act.Type != "delete" {
+			audited.ContentHash = contentHash([]byte(act.Content))
+			audited.Bytes = len(act.Content)
+		}
+		manifest.Actions = append(manifest.Actions, audited)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode audit manifest: %v", err)
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Failed to create audit directory %s: %v", dir, err)
+		return
+	}
+
+	// Sortable by time; the nanoseconds keep concurrent batches apart
+	name := fmt.Sprintf("%s.json", manifest.Timestamp.Format("20060102T150405.000000000Z"))
+	if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		log.Printf("Failed to write audit manifest: %v", err)
+	}
+}
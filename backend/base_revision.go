@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// The commit checked out in the project's git repository, or "" when the
+// project isn't in one. Recorded when the context is gathered so apply can
+// tell whether the repository moved on in the meantime.
+func currentRevision(ctx context.Context) string {
+	head, err := runGit(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(head)
+}
+
+// Rejects the whole batch with a conflict when HEAD has moved past
+// baseRevision and any file the actions touch differs between the two
+// commits, so edits based on an older revision can't silently overwrite
+// someone else's committed work. Edits to files nobody else changed go
+// through. Returns nil when baseRevision is "" or still checked out.
+func checkBaseRevision(ctx context.Context, baseRevision string, edits AIEditActions) error {
+	if baseRevision == "" {
+		return nil
+	}
+	head := currentRevision(ctx)
+	if head == "" {
+		return newAPIError(http.StatusConflict, errCodeConflict,
+			"base_revision was given but the project is no longer in a git repository with commits", nil)
+	}
+	// Resolve the revision the client sent, which may be abbreviated, so
+	// only the exact commit counts as current, not any prefix of HEAD
+	resolved := ""
+	if !strings.HasPrefix(baseRevision, "-") {
+		if out, err := runGit(ctx, "rev-parse", "--verify", "--quiet", baseRevision+"^{commit}"); err == nil {
+			resolved = strings.TrimSpace(out)
+		}
+	}
+	if resolved == "" {
+		return newAPIError(http.StatusConflict, errCodeConflict,
+			fmt.Sprintf("base_revision %s is not a commit in this repository", baseRevision),
+			map[string]string{"base_revision": baseRevision, "head": head})
+	}
+	if resolved == head {
+		return nil
+	}
+	baseRevision = resolved
+
+	var paths []string
+	for _, act := range edits.Actions {
+		if rel, err := filepath.Rel(projectRoot, projectFilePath(actionPath(act.Path))); err == nil {
+			paths = append(paths, rel)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	changed, err := runGit(ctx, append([]string{"diff", "--name-only", baseRevision, head, "--"}, paths...)...)
+	if err != nil {
+		return newAPIError(http.StatusConflict, errCodeConflict,
+			"Failed to compare base_revision with HEAD: "+gitErrorText(err),
+			map[string]string{"base_revision": baseRevision, "head": head})
+	}
+	if files := strings.Fields(changed); len(files) > 0 {
+		return newAPIError(http.StatusConflict, errCodeConflict,
+			fmt.Sprintf("The repository moved from %s to %s and %d of the files these edits touch changed since. "+
+				"Re-run the edit against the current project.", shortRevision(baseRevision), shortRevision(head), len(files)),
+			map[string]interface{}{"base_revision": baseRevision, "head": head, "files": files})
+	}
+	return nil
+}
+
+func shortRevision(revision string) string {
+	if len(revision) > 12 {
+		return revision[:12]
+	}
+	return revision
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Runs each instruction in req.Batch as its own edit, in order. Every step
+// gathers the context again, so it sees the files written by the steps
+// before it. Stops at the first failed step unless continue_on_error is set.
+func serveBatch(w http.ResponseWriter, r *http.Request, req EditRequest) {
+	ctx, tracker := startProgress(r, req)
+	ctx, branch, err := startEditBranch(ctx)
+	if err != nil {
+		tracker.finish(err)
+		writeAPIError(w, err)
+		return
+	}
+	defer branch.finish()
+
+	steps := make([]map[string]interface{}, 0, len(req.Batch))
+	failed := 0
+
+	for i, instructions := range req.Batch {
+		stepReq := req
+		stepReq.Batch = nil
+		stepReq.Instructions = instructions
+
+		tracker.setStep(i+1, len(req.Batch))
+		step, err := runBatchStep(ctx, r, stepReq, branch)
+		if err != nil {
+			log.Printf("Batch step %d of %d failed: %v", i+1, len(req.Batch), err)
+			failed++
+			step = map[string]interface{}{"status": "error", "error": apiErrorBody(err)}
+		}
+		step["instructions"] = instructions
+		steps = append(steps, step)
+
+		if err != nil && !req.ContinueOnError {
+			break
+		}
+	}
+
+	status := "success"
+	switch {
+	case failed == len(steps):
+		status = "failed"
+	case failed > 0:
+		status = "partial"
+	}
+	if failed > 0 {
+		tracker.finish(fmt.Errorf("%d of %d batch steps failed", failed, len(req.Batch)))
+	} else {
+		tracker.finish(nil)
+	}
+
+	response := map[string]interface{}{
+		"status": status,
+		"steps":  steps,
+	}
+	if branch != nil {
+		response["branch"] = branch
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Generates and applies one batch step and returns its /api/edit style
+// result. With GIT_BRANCH_PER_EDIT each step is written to the branch's
+// worktree and is its own commit there.
+func runBatchStep(ctx context.Context, r *http.Request, req EditRequest, branch *EditBranch) (map[string]interface{}, error) {
+	gen, err := generateEdits(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := applyOptionsFor(req)
+	opts.BaseHashes = gen.BaseHashes
+	opts.BaseRevision = gen.BaseRevision
+	branch.applyTo(&opts)
+	result, err := applyEdits(ctx, gen.Edits, opts)
+	if err != nil {
+		return nil, err
+	}
+	req.Provider, req.Model = gen.Provider, gen.Model
+	writeAuditManifest(r.URL.Path, req, gen.Edits.Actions, result)
+	response := buildApplyResponse(result, opts)
+	response["provider"] = gen.Provider
+	response["model"] = gen.Model
+	addModelMessage(response, gen.Edits)
+	if req.IncludeContent {
+		response["files"] = appliedFiles(result.Changed, req.KnownFiles, opts)
+	}
+	if gen.Usage != nil {
+		response["usage"] = gen.Usage
+	}
+	branch.commit(ctx, result.Changed, req.Instructions)
+	return response, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Most candidates one request may ask for unless MAX_CANDIDATES says otherwise
+const defaultMaxCandidates = 5
+
+// One of several alternative edits generated for the same instructions
+type Candidate struct {
+	Index   int           `json:"index"`
+	Actions []EditAction  `json:"actions,omitempty"`
+	Files   []PreviewFile `json:"files,omitempty"`
+	// The model's commentary next to the actions, see modelMessage
+	ModelMessage string `json:"model_message,omitempty"`
+	// Set instead of Actions when this reply couldn't be used
+	Error map[string]interface{} `json:"error,omitempty"`
+}
+
+// Asks the model for req.N alternative edits to the same prompt and returns
+// each with its per-file diffs, like /api/preview. Nothing is applied; the
+// client sends the chosen candidate's actions with base_hashes and
+// base_revision to /api/apply. Replies that can't be parsed are returned
+// with an error instead of failing the request, unless none can.
+func serveCandidates(w http.ResponseWriter, r *http.Request, req EditRequest) {
+	if limit := envInt("MAX_CANDIDATES", defaultMaxCandidates); req.N > limit {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("n is %d; at most %d candidates can be requested (MAX_CANDIDATES)", req.N, limit), nil)
+		return
+	}
+
+	ctx, tracker := startProgress(r, req)
+	req.Model = resolveModelAlias(req.Model)
+	prompt, err := buildEditPrompt(ctx, req)
+	if err != nil {
+		tracker.finish(err)
+		writeAPIError(w, err)
+		return
+	}
+
+	var responses []string
+	var usage *TokenUsage
+	req, prompt, err = withFallbacks(req, prompt, func(req EditRequest, prompt *EditPrompt) error {
+		var err error
+		responses, usage, err = callProviderChoices(ctx, req, prompt.Text, req.N)
+		return err
+	})
+	if err != nil {
+		tracker.finish(err)
+		writeAPIError(w, err)
+		return
+	}
+
+	candidates := make([]Candidate, 0, len(responses))
+	var firstErr error
+	parsed := 0
+	for i, response := range responses {
+		gen, err := parseGeneration(ctx, req, prompt, response, nil)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			candidates = append(candidates, Candidate{Index: i, Error: apiErrorBody(err)})
+			continue
+		}
+		parsed++
+		candidates = append(candidates, Candidate{Index: i, Actions: gen.Edits.Actions, Files: previewEdits(gen.Edits), ModelMessage: gen.Edits.modelMessage()})
+	}
+	if parsed == 0 {
+		tracker.finish(firstErr)
+		writeAPIError(w, firstErr)
+		return
+	}
+	tracker.finish(nil)
+
+	response := map[string]interface{}{
+		"status":     "success",
+		"candidates": candidates,
+		// Send these back to /api/apply with the chosen candidate's actions
+		"base_hashes":   prompt.BaseHashes,
+		"base_revision": prompt.BaseRevision,
+		"provider":      req.Provider,
+		"model":         req.Model,
+	}
+	if usage != nil {
+		response["usage"] = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// Shared transport for all outgoing provider requests, so keep-alive
+// connections and TLS sessions are reused across edits
+var providerTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   10,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: 1 * time.Second,
+}
+
+var (
+	// Client for generation requests. It has no overall timeout because
+	// generations can run for minutes; callers set a deadline on the request
+	// context instead.
+	providerClient = &http.Client{Transport: providerTransport}
+
+	// Client for small metadata requests such as model lists
+	metadataClient = &http.Client{Transport: providerTransport, Timeout: 10 * time.Second}
+)
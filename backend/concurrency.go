@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	providerSlotsOnce sync.Once
+	providerSlots     chan struct{} // nil when PROVIDER_CONCURRENCY is unset or 0 (unlimited)
+)
+
+// Waits for one of the PROVIDER_CONCURRENCY upstream call slots, so bursts
+// from several sessions queue here instead of all hitting provider rate
+// limits at once. Gives up with a 503 *APIError after PROVIDER_QUEUE_TIMEOUT
+// or when ctx is done. The returned function frees the slot.
+func acquireProviderSlot(ctx context.Context, provider string) (func(), error) {
+	providerSlotsOnce.Do(func() {
+		if limit := envInt("PROVIDER_CONCURRENCY", 0); limit > 0 {
+			providerSlots = make(chan struct{}, limit)
+		}
+	})
+	if providerSlots == nil {
+		return func() {}, nil
+	}
+
+	started := time.Now()
+	timeout := envDuration("PROVIDER_QUEUE_TIMEOUT", 30*time.Second)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case providerSlots <- struct{}{}:
+	case <-timer.C:
+		return nil, newAPIError(http.StatusServiceUnavailable, errCodeRateLimited,
+			fmt.Sprintf("Too many concurrent provider calls; no slot freed up within %s", timeout), nil)
+	case <-ctx.Done():
+		return nil, newAPIError(http.StatusServiceUnavailable, errCodeRateLimited,
+			fmt.Sprintf("Timed out waiting for a provider slot: %v", ctx.Err()), nil)
+	}
+
+	if wait := time.Since(started); wait > 10*time.Millisecond {
+		log.Printf("Waited %s in the queue for a %s call slot", wait.Round(time.Millisecond), provider)
+	}
+	return func() { <-providerSlots }, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Optional settings are read from the environment at the point of use, the
+// same way OPENROUTER_API_KEY is. Values from the env files are in the
+// environment from startup on, see loadEnvFiles.
+
+// Reads a string setting without surrounding whitespace, "" when unset
+func getenvTrimmed(key string) string {
+	return strings.TrimSpace(os.Getenv(key))
+}
+
+// Reads a boolean setting ("1", "true", "yes", "on"), falling back to def
+func envBool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	switch strings.ToLower(v) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return def
+}
+
+// Reads an integer setting, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// Reads a duration setting. Plain numbers are treated as seconds, anything
+// else is parsed with time.ParseDuration (e.g. "90s", "2m").
+func envDuration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	d, err := parseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// Parses a duration the way envDuration does: plain numbers are seconds
+func parseDuration(v string) (time.Duration, error) {
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// Reads a comma separated list setting, dropping empty entries
+func envList(key string) []string {
+	var list []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Bundled JSON schemas for the config files the model may edit
+//
+//go:embed schemas
+var configSchemaFiles embed.FS
+
+// Config file names and the bundled schema each is checked against
+var configSchemaNames = map[string]string{
+	"package.json":  "schemas/package.json.schema.json",
+	"tsconfig.json": "schemas/tsconfig.schema.json",
+}
+
+// At most this many violations are listed in a skip reason
+const maxSchemaViolations = 5
+
+// The subset of JSON Schema the bundled schemas use. $ref only supports
+// "#/definitions/<name>".
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref"`
+	Type                 schemaTypes            `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Pattern              string                 `json:"pattern"`
+	Definitions          map[string]*jsonSchema `json:"definitions"`
+}
+
+// "type" may be a single name or a list of names
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = schemaTypes{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*t = list
+	return nil
+}
+
+// The bundled schema for a config file path (tsconfig.app.json and the like
+// count as tsconfig.json), or nil when the file isn't a known config file
+func configSchemaFor(path string) *jsonSchema {
+	base := strings.ToLower(filepath.Base(path))
+	if isTsconfig(path) {
+		base = "tsconfig.json"
+	}
+	name, ok := configSchemaNames[base]
+	if !ok {
+		return nil
+	}
+	data, err := configSchemaFiles.ReadFile(name)
+	if err != nil {
+		log.Printf("Failed to read bundled schema %s: %v", name, err)
+		return nil
+	}
+	var schema jsonSchema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		log.Printf("Failed to parse bundled schema %s: %v", name, err)
+		return nil
+	}
+	return &schema
+}
+
+// Checks a config file the model wrote against its bundled schema when
+// VALIDATE_CONFIG_FILES is on (the default). Returns why the edit must be
+// rejected, or "". tsconfig files may contain comments and trailing commas.
+func configFileRejection(path, content string) string {
+	if !envBool("VALIDATE_CONFIG_FILES", true) {
+		return ""
+	}
+	schema := configSchemaFor(path)
+	if schema == nil {
+		return ""
+	}
+
+	text := content
+	if isTsconfig(path) {
+		text = stripTrailingCommas(stripJSONComments(text))
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return "config file is not valid JSON: " + err.Error()
+	}
+
+	var violations []string
+	schema.validate(schema, value, "", &violations)
+	if len(violations) == 0 {
+		return ""
+	}
+	sort.Strings(violations)
+	if len(violations) > maxSchemaViolations {
+		violations = append(violations[:maxSchemaViolations], fmt.Sprintf("and %d more", len(violations)-maxSchemaViolations))
+	}
+	return "config file violates its schema: " + strings.Join(violations, "; ")
+}
+
+// Reports whether path is a TypeScript config, such as tsconfig.json or
+// tsconfig.app.json
+func isTsconfig(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	return strings.HasPrefix(base, "tsconfig.") && strings.HasSuffix(base, ".json")
+}
+
+// Appends a message for every way value breaks s to violations. root holds
+// the definitions $ref points into; at is the dotted path of value.
+func (s *jsonSchema) validate(root *jsonSchema, value interface{}, at string, violations *[]string) {
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/definitions/")
+		target, ok := root.Definitions[name]
+		if !ok {
+			log.Printf("Ignoring unknown schema reference %s", s.Ref)
+			return
+		}
+		target.validate(root, value, at, violations)
+		return
+	}
+
+	fail := func(format string, args ...interface{}) {
+		where := at
+		if where == "" {
+			where = "(root)"
+		}
+		*violations = append(*violations, where+": "+fmt.Sprintf(format, args...))
+	}
+
+	if len(s.Type) > 0 && !matchesSchemaType(value, s.Type) {
+		fail("must be %s, not %s", strings.Join(s.Type, " or "), jsonTypeName(value))
+		return
+	}
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if allowed == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			var names []string
+			for _, allowed := range s.Enum {
+				names = append(names, fmt.Sprintf("%v", allowed))
+			}
+			fail("must be one of %s", strings.Join(names, ", "))
+			return
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if s.Pattern != "" {
+			re, err := regexp.Compile(s.Pattern)
+			if err != nil {
+				log.Printf("Ignoring invalid schema pattern %q: %v", s.Pattern, err)
+			} else if !re.MatchString(v) {
+				fail("%q is not a valid value", v)
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(root, item, fmt.Sprintf("%s[%d]", at, i), violations)
+			}
+		}
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				fail("missing required field %q", name)
+			}
+		}
+
+		var additional *jsonSchema
+		additionalAllowed := true
+		if len(s.AdditionalProperties) > 0 {
+			if err := json.Unmarshal(s.AdditionalProperties, &additionalAllowed); err != nil {
+				additionalAllowed = true
+				additional = &jsonSchema{}
+				if err := json.Unmarshal(s.AdditionalProperties, additional); err != nil {
+					additional = nil
+				}
+			}
+		}
+		for name, field := range v {
+			fieldAt := name
+			if at != "" {
+				fieldAt = at + "." + name
+			}
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(root, field, fieldAt, violations)
+				continue
+			}
+			if !additionalAllowed {
+				fail("unknown field %q", name)
+				continue
+			}
+			if additional != nil {
+				additional.validate(root, field, fieldAt, violations)
+			}
+		}
+	}
+}
+
+func matchesSchemaType(value interface{}, types []string) bool {
+	name := jsonTypeName(value)
+	for _, t := range types {
+		if t == name || (t == "integer" && name == "number" && value.(float64) == float64(int64(value.(float64)))) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// Removes commas directly before a closing } or ], which tsconfig allows
+// but encoding/json doesn't, leaving string literals untouched
+func stripTrailingCommas(text string) string {
+	var out strings.Builder
+	out.Grow(len(text))
+
+	inString := false
+	escaped := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		if inString {
+			out.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		if c == ',' {
+			next := i + 1
+			for next < len(text) && strings.IndexByte(" \t\r\n", text[next]) >= 0 {
+				next++
+			}
+			if next < len(text) && (text[next] == '}' || text[next] == ']') {
+				continue
+			}
+		}
+		if c == '"' {
+			inString = true
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Example values from buildPrompt that models sometimes copy verbatim
+// instead of writing real code
+var placeholderContents = []string{
+	"<full file content here>",
+	"<new file content>",
+	"<file content>",
+}
+
+// Largest content a single action may write unless MAX_ACTION_CONTENT_BYTES says otherwise
+const defaultMaxActionContentBytes = 1 << 20
+
+// Rejects a create or update whose content is missing or only whitespace,
+// which almost always means the model forgot it rather than wanting a blank
+// file. ALLOW_EMPTY_CONTENT=true writes such files anyway.
+func emptyContentRejection(act EditAction) string {
+	if act.Type != "create" && act.Type != "update" {
+		return ""
+	}
+	if strings.TrimSpace(act.Content) != "" || envBool("ALLOW_EMPTY_CONTENT", false) {
+		return ""
+	}
+	if act.Content == "" {
+		return fmt.Sprintf("%s has no content; the model probably left it out. Re-run the edit", act.Type)
+	}
+	return fmt.Sprintf("%s content is only whitespace; the model probably left it out. Re-run the edit", act.Type)
+}
+
+// Checks the content of a create/update action before it is written and
+// returns why it must be rejected, or "" when it is fine to write. original
+// is the file's current content, "" for a new file.
+func contentRejection(act EditAction, original string) string {
+	if limit := envInt("MAX_ACTION_CONTENT_BYTES", defaultMaxActionContentBytes); limit > 0 && len(act.Content) > limit {
+		return fmt.Sprintf("content is %d bytes, over the %d byte limit", len(act.Content), limit)
+	}
+	// encoding/json swaps invalid sequences in string values for U+FFFD, so
+	// a replacement character the file didn't already have means the reply
+	// wasn't valid UTF-8
+	if !utf8.ValidString(act.Content) ||
+		(strings.ContainsRune(act.Content, utf8.RuneError) && !strings.ContainsRune(original, utf8.RuneError)) {
+		return "content is not valid UTF-8"
+	}
+	for _, placeholder := range placeholderContents {
+		if strings.Contains(act.Content, placeholder) {
+			return fmt.Sprintf("content contains the prompt placeholder %q instead of real code", placeholder)
+		}
+	}
+	if source := forbiddenImport(act.Path, act.Content); source != "" {
+		return fmt.Sprintf("imports %q, which is on the FORBIDDEN_IMPORTS list", source)
+	}
+	if reason := forbiddenContent(act.Content); reason != "" {
+		return reason
+	}
+	return ""
+}
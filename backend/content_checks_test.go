@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestContentRejectionInvalidUTF8(t *testing.T) {
+	// A reply whose content holds a stray Latin-1 byte, as sent by a
+	// provider that garbled the encoding
+	reply := "{\"actions\":[{\"type\":\"create\",\"path\":\"src/App.tsx\",\"content\":\"const caf\xe9 = 1;\\n\"}]}"
+	edits, err := decodeEditActions(reply)
+	if err != nil {
+		t.Fatalf("decodeEditActions: %v", err)
+	}
+	if len(edits.Actions) != 1 {
+		t.Fatalf("got %d actions, want 1", len(edits.Actions))
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		original string
+		rejected bool
+	}{
+		{"decoded invalid bytes", edits.Actions[0].Content, "", true},
+		{"raw invalid bytes", "const caf\xe9 = 1;\n", "", true},
+		{"valid non-ASCII", "const café = '☕';\n", "", false},
+		{"replacement char already in the file", "const bad = '�';\nconst b = 2;\n", "const bad = '�';\n", false},
+		{"new replacement char in an existing file", "const bad = '�';\n", "const bad = 'x';\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			act := EditAction{Type: "update", Path: "src/App.tsx", Content: tt.content}
+			reason := contentRejection(act, tt.original)
+			if tt.rejected && reason == "" {
+				t.Errorf("content %q was accepted, want it rejected", tt.content)
+			}
+			if !tt.rejected && reason != "" {
+				t.Errorf("content %q was rejected: %s", tt.content, reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Prefix marking a FORBIDDEN_CONTENT_FILE line as a regular expression
+const forbiddenRegexPrefix = "re:"
+
+// One entry of the content block-list: a plain substring or a regex
+type contentRule struct {
+	text    string // as written in the configuration, used in rejection messages
+	literal string
+	pattern *regexp.Regexp
+}
+
+// Compiled regexes by source, so the file can be re-read for every action
+// without recompiling
+var contentRegexCache sync.Map
+
+// Collects the block-list from FORBIDDEN_CONTENT (comma-separated substrings)
+// and FORBIDDEN_CONTENT_FILE (one entry per line, "re:" for a regex, "#" for
+// comments). Regexes that don't compile are logged and ignored.
+func forbiddenContentRules() []contentRule {
+	var rules []contentRule
+	for _, literal := range envList("FORBIDDEN_CONTENT") {
+		rules = append(rules, contentRule{text: literal, literal: literal})
+	}
+
+	file := strings.TrimSpace(os.Getenv("FORBIDDEN_CONTENT_FILE"))
+	if file == "" {
+		return rules
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Printf("Ignoring FORBIDDEN_CONTENT_FILE: %v", err)
+		return rules
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		source, isRegex := strings.CutPrefix(line, forbiddenRegexPrefix)
+		if !isRegex {
+			rules = append(rules, contentRule{text: line, literal: line})
+			continue
+		}
+		pattern, err := compileContentRegex(source)
+		if err != nil {
+			log.Printf("Ignoring FORBIDDEN_CONTENT_FILE pattern %q: %v", source, err)
+			continue
+		}
+		rules = append(rules, contentRule{text: line, pattern: pattern})
+	}
+	return rules
+}
+
+func compileContentRegex(source string) (*regexp.Regexp, error) {
+	if cached, ok := contentRegexCache.Load(source); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+	pattern, err := regexp.Compile(source)
+	if err != nil {
+		return nil, err
+	}
+	contentRegexCache.Store(source, pattern)
+	return pattern, nil
+}
+
+// Returns why content must be rejected under the block-list, or "". The
+// message names the rule and line but not the match, which may be a secret.
+func forbiddenContent(content string) string {
+	for _, rule := range forbiddenContentRules() {
+		index := -1
+		if rule.pattern != nil {
+			if loc := rule.pattern.FindStringIndex(content); loc != nil {
+				index = loc[0]
+			}
+		} else {
+			index = strings.Index(content, rule.literal)
+		}
+		if index >= 0 {
+			line := strings.Count(content[:index], "\n") + 1
+			return fmt.Sprintf("line %d matches the forbidden content rule %q", line, rule.text)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Most omitted paths listed in the prompt; beyond this only the count is given
+const maxListedOmittedFiles = 200
+
+// Keeps at most MAX_CONTEXT_FILES source files for the prompt, preferring
+// essential files, then files whose name or content mentions words from
+// the instructions, then recently modified ones. Reference-only files don't
+// count towards the limit. Returns the kept files in their original order
+// and the "src/..." paths of the ones left out.
+func limitContextFiles(files []FileJSON, instructions string) ([]FileJSON, []string) {
+	limit := envInt("MAX_CONTEXT_FILES", 0)
+	sourceCount := 0
+	for _, file := range files {
+		if !file.ReadOnly {
+			sourceCount++
+		}
+	}
+	if limit <= 0 || sourceCount <= limit {
+		return files, nil
+	}
+
+	keywords := instructionKeywords(instructions)
+	type candidate struct {
+		index    int
+		score    int
+		modified time.Time
+	}
+	var candidates []candidate
+	for i, file := range files {
+		if file.ReadOnly {
+			continue
+		}
+		c := candidate{index: i}
+		path := contextFileKey(file)
+		if isEssentialFile(path) {
+			c.score += 1000
+		}
+		name := strings.ToLower(strings.TrimSuffix(filepath.Base(file.Path), filepath.Ext(file.Path)))
+		content := strings.ToLower(file.Content)
+		for _, keyword := range keywords {
+			if strings.Contains(name, keyword) {
+				c.score += 10
+			}
+			if strings.Contains(content, keyword) {
+				c.score++
+			}
+		}
+		if info, err := os.Stat(projectFilePath(path)); err == nil {
+			c.modified = info.ModTime()
+		}
+		candidates = append(candidates, c)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].modified.After(candidates[j].modified)
+	})
+
+	dropped := make(map[int]bool, len(candidates)-limit)
+	for _, c := range candidates[limit:] {
+		dropped[c.index] = true
+	}
+	kept := make([]FileJSON, 0, len(files)-len(dropped))
+	var omitted []string
+	for i, file := range files {
+		if dropped[i] {
+			omitted = append(omitted, contextFileKey(file))
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept, omitted
+}
+
+// Lowercased words of three or more letters or digits from the instructions
+func instructionKeywords(instructions string) []string {
+	seen := map[string]bool{}
+	var keywords []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(instructions), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) >= 3 && !seen[word] {
+			seen[word] = true
+			keywords = append(keywords, word)
+		}
+	}
+	return keywords
+}
+
+// Tells the model which files exist but were left out of the prompt, so it
+// doesn't recreate or blindly overwrite them
+func omittedFilesNote(omitted []string) string {
+	if len(omitted) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\nNOTE: %d more project files exist but their content was left out to keep this prompt small. "+
+		"Do NOT create, update or delete them, since you haven't seen what they contain:\n", len(omitted))
+	for i, path := range omitted {
+		if i == maxListedOmittedFiles {
+			fmt.Fprintf(&b, "- ... and %d more\n", len(omitted)-i)
+			break
+		}
+		fmt.Fprintf(&b, "- %s\n", path)
+	}
+	return b.String()
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// Env vars with per-directory allow rules for each kind of action
+var directoryRuleVars = map[string]string{
+	"create":        "ALLOW_CREATE",
+	"update":        "ALLOW_UPDATE",
+	"replace-range": "ALLOW_UPDATE",
+	"delete":        "ALLOW_DELETE",
+}
+
+// Checks an action against the per-directory rules, e.g.
+// ALLOW_CREATE="src/components=false,src/components/forms=true". The
+// longest matching prefix decides; paths no rule matches are allowed.
+// Returns why the action is not allowed, or "" when it is.
+func directoryRuleRejection(actionType, normalizedPath string) string {
+	key, ok := directoryRuleVars[actionType]
+	if !ok {
+		return ""
+	}
+
+	matched := ""
+	allowed := true
+	for _, entry := range envList(key) {
+		prefix, value, ok := strings.Cut(entry, "=")
+		prefix = strings.TrimSuffix(strings.TrimSpace(prefix), "/")
+		allow, err := strconv.ParseBool(strings.TrimSpace(value))
+		if !ok || prefix == "" || err != nil {
+			log.Printf("Ignoring %s entry %q (want prefix=true|false)", key, entry)
+			continue
+		}
+		if normalizedPath != prefix && !strings.HasPrefix(normalizedPath, prefix+"/") {
+			continue
+		}
+		if len(prefix) > len(matched) {
+			matched, allowed = prefix, allow
+		}
+	}
+
+	if allowed {
+		return ""
+	}
+	return fmt.Sprintf("%s is not allowed in %s (%s rule %s=false)", actionType, matched, key, matched)
+}
+
+// Files the app can't start without, used when ESSENTIAL_FILES is unset
+var defaultEssentialFiles = []string{"src/main.tsx", "src/App.tsx", "index.html"}
+
+// Reports whether a normalized path is on the ESSENTIAL_FILES list, which
+// may never be deleted. Entries are normalized like action paths, so
+// "App.tsx" and "src/App.tsx" are the same entry.
+func isEssentialFile(normalizedPath string) bool {
+	essential := envList("ESSENTIAL_FILES")
+	if len(essential) == 0 {
+		essential = defaultEssentialFiles
+	}
+	for _, entry := range essential {
+		if strings.EqualFold(normalizePath(entry), normalizedPath) {
+			return true
+		}
+	}
+	return false
+}
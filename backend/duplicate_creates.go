@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// Finds files that one batch creates more than once and decides which of
+// those creates to drop, so the outcome doesn't depend on action order by
+// accident. DUPLICATE_CREATE_MODE picks the survivor when the contents
+// differ: "keep-last" (default, what writing them in order would do),
+// "keep-first", or "reject" to drop them all. Repeats with identical
+// content are dropped quietly. Returns the reason for every dropped action
+// by index, and the paths created with conflicting contents.
+func duplicateCreates(actions []EditAction) (map[int]string, []string) {
+	byPath := map[string][]int{}
+	var order []string
+	for i, act := range actions {
+		if act.Type != "create" {
+			continue
+		}
+		path := actionPath(act.Path)
+		if _, seen := byPath[path]; !seen {
+			order = append(order, path)
+		}
+		byPath[path] = append(byPath[path], i)
+	}
+
+	mode := strings.ToLower(os.Getenv("DUPLICATE_CREATE_MODE"))
+	dropped := map[int]string{}
+	var conflicting []string
+	for _, path := range order {
+		indexes := byPath[path]
+		if len(indexes) < 2 {
+			continue
+		}
+
+		identical := true
+		for _, i := range indexes[1:] {
+			if actions[i].Content != actions[indexes[0]].Content {
+				identical = false
+			}
+		}
+		if identical {
+			for _, i := range indexes[1:] {
+				dropped[i] = "repeats an identical create of the same file"
+			}
+			continue
+		}
+
+		conflicting = append(conflicting, path)
+		switch mode {
+		case "reject":
+			log.Printf("Warning: %d creates of %s with different content; rejecting all of them", len(indexes), path)
+			for _, i := range indexes {
+				dropped[i] = fmt.Sprintf("the batch creates this file %d times with different content (DUPLICATE_CREATE_MODE=reject)", len(indexes))
+			}
+		case "keep-first":
+			log.Printf("Warning: %d creates of %s with different content; keeping the first", len(indexes), path)
+			for _, i := range indexes[1:] {
+				dropped[i] = "an earlier create of this file in the batch was kept (DUPLICATE_CREATE_MODE=keep-first)"
+			}
+		default:
+			log.Printf("Warning: %d creates of %s with different content; keeping the last", len(indexes), path)
+			for _, i := range indexes[:len(indexes)-1] {
+				dropped[i] = "a later create of this file in the batch was kept (DUPLICATE_CREATE_MODE=keep-last)"
+			}
+		}
+	}
+	return dropped, conflicting
+}
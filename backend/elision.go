@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Comment text that stands in for code the model left out, such as
+// "... existing code ...", "rest of the component unchanged" or a bare "..."
+var elisionPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^(\.\.\.|…)$`),
+	regexp.MustCompile(`(\.\.\.|…).*\b(existing|rest|remaining|unchanged|same|previous|omitted|other|more)\b`),
+	regexp.MustCompile(`\b(existing|rest|remaining|unchanged|same|previous|omitted|other)\b.*(\.\.\.|…)`),
+	regexp.MustCompile(`\brest of (the )?(code|file|component|implementation|function|class|styles?|jsx|imports|logic)\b`),
+	regexp.MustCompile(`\b(code|everything|rest|implementation)( else)? (remains|stays|is) (the same|unchanged|as before)\b`),
+	regexp.MustCompile(`\b(existing|unchanged|previous|same as before) (code|imports|logic|implementation|jsx|styles?)( here| goes here| remains| unchanged)?$`),
+}
+
+// Comment openers an elision marker line may start with, longest first
+var elisionCommentPrefixes = []string{"{/*", "/*", "//", "<!--", "#"}
+
+// Returns the text of a comment-only line, or false when the line isn't one.
+// A bare "..." counts too, since it isn't valid code on its own line.
+func commentLineText(line string) (string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "..." || trimmed == "…" {
+		return trimmed, true
+	}
+	for _, prefix := range elisionCommentPrefixes {
+		if rest, ok := strings.CutPrefix(trimmed, prefix); ok {
+			for _, suffix := range []string{"*/}", "*/", "-->"} {
+				rest = strings.TrimSuffix(strings.TrimSpace(rest), suffix)
+			}
+			return strings.TrimSpace(rest), true
+		}
+	}
+	return "", false
+}
+
+// Reports whether a line is a comment standing in for omitted code
+func isElisionMarker(line string) bool {
+	text, ok := commentLineText(line)
+	if !ok {
+		return false
+	}
+	text = strings.ToLower(text)
+	for _, pattern := range elisionPatterns {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// Indexes of the elision marker lines in content that original doesn't
+// already contain, so comments the file really has are left alone
+func elisionMarkers(content, original string) []int {
+	existing := map[string]bool{}
+	for _, line := range strings.Split(original, "\n") {
+		existing[strings.TrimSpace(line)] = true
+	}
+	var markers []int
+	for i, line := range strings.Split(content, "\n") {
+		if isElisionMarker(line) && !existing[strings.TrimSpace(line)] {
+			markers = append(markers, i)
+		}
+	}
+	return markers
+}
+
+// Checks new content for an existing file for elision markers, which would
+// delete the code they stand for. ELISION_MODE decides what happens:
+// "reject" (default) refuses the action, "merge" puts the original code back
+// where each marker is when the lines around it can be found in the
+// original (and rejects otherwise), "off" writes the content as-is.
+// Returns the content to write, whether it was merged, and a non-empty
+// reason when the action must be rejected.
+func checkElisions(content, original string) (string, bool, string) {
+	mode := strings.ToLower(getenvTrimmed("ELISION_MODE"))
+	if mode == "off" {
+		return content, false, ""
+	}
+	markers := elisionMarkers(content, original)
+	if len(markers) == 0 {
+		return content, false, ""
+	}
+
+	lines := strings.Split(content, "\n")
+	first := strings.TrimSpace(lines[markers[0]])
+	if mode == "merge" {
+		if merged, ok := mergeElisions(lines, markers, strings.Split(original, "\n")); ok {
+			return merged, true, ""
+		}
+		return content, false, fmt.Sprintf("content elides existing code (%q on line %d) and the original code around it couldn't be found to merge it back", first, markers[0]+1)
+	}
+	return content, false, fmt.Sprintf("content elides existing code (%q on line %d); writing it would delete the code the marker stands for. Ask the model for the complete file", first, markers[0]+1)
+}
+
+// Replaces each marker with the original lines it stands for. The gap is
+// anchored on distinctive lines (not blank, not just a brace) found in the
+// original in order; the short lines between a marker and its anchor are
+// assumed to match the original line for line. Reports false when an anchor
+// can't be found after the previous one.
+func mergeElisions(lines []string, markers []int, original []string) (string, bool) {
+	isMarker := map[int]bool{}
+	for _, i := range markers {
+		isMarker[i] = true
+	}
+	distinctive := func(line string) bool {
+		return len(strings.TrimSpace(line)) >= 4
+	}
+	find := func(line string, from int) int {
+		for i := from; i < len(original); i++ {
+			if strings.TrimSpace(original[i]) == strings.TrimSpace(line) {
+				return i
+			}
+		}
+		return -1
+	}
+
+	var out []string
+	pos := 0 // original lines before pos are accounted for
+	for i := 0; i < len(lines); i++ {
+		if !isMarker[i] {
+			if distinctive(lines[i]) {
+				if at := find(lines[i], pos); at >= 0 {
+					pos = at + 1
+				}
+			} else if pos < len(original) && strings.TrimSpace(original[pos]) == strings.TrimSpace(lines[i]) {
+				pos++
+			}
+			out = append(out, lines[i])
+			continue
+		}
+
+		end := len(original) - (len(lines) - i - 1)
+		for k := i + 1; k < len(lines); k++ {
+			if isMarker[k] {
+				break
+			}
+			if distinctive(lines[k]) {
+				at := find(lines[k], pos)
+				if at < 0 {
+					return "", false
+				}
+				end = at - (k - i - 1)
+				break
+			}
+		}
+		if end < pos {
+			return "", false
+		}
+		out = append(out, original[pos:end]...)
+		pos = end
+	}
+	return strings.Join(out, "\n"), true
+}
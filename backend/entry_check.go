@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Ways a module can export a default: "export default ...",
+// "export { App as default }" and "export { default } from './App'"
+var defaultExportPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\bexport\s+default\b`),
+	regexp.MustCompile(`\bexport\s*\{[^}]*\bas\s+default\b[^}]*\}`),
+	regexp.MustCompile(`\bexport\s*\{[^}]*\bdefault\b[^}]*\}\s*from\b`),
+}
+
+// The outcome of the ENTRY_EXPORT_CHECK after an apply that changed the entry
+type EntryExportIssue struct {
+	Path       string `json:"path"`
+	Issue      string `json:"issue"`
+	RolledBack bool   `json:"rolled_back"` // the entry file was restored to its content before the apply
+}
+
+// The ENTRY_EXPORT_CHECK mode: "off" (default), "warn" to report an entry
+// file that lost its default export, "rollback" to also restore it
+func entryExportMode() string {
+	switch mode := strings.ToLower(getenvTrimmed("ENTRY_EXPORT_CHECK")); mode {
+	case "warn", "rollback":
+		return mode
+	default:
+		return "off"
+	}
+}
+
+// The file that must keep a default export, from ENTRY_FILE (default
+// src/App.tsx), normalized like action paths
+func entryFilePath() string {
+	if entry := getenvTrimmed("ENTRY_FILE"); entry != "" {
+		return normalizePath(entry)
+	}
+	return "src/App.tsx"
+}
+
+// Reports whether a script has a default export, ignoring commented out ones
+func hasDefaultExport(content string) bool {
+	content = stripJSONComments(content)
+	for _, pattern := range defaultExportPatterns {
+		if pattern.MatchString(content) {
+			return true
+		}
+	}
+	return false
+}
+
+// The entry file's content before the edits touch it, so a lost default
+// export can be rolled back. Returns nil when the check is off, the edits
+// don't target the entry or it doesn't exist yet.
+func snapshotEntry(edits AIEditActions, opts ApplyOptions) []byte {
+	entry := entryFilePath()
+	for _, act := range edits.Actions {
+		if actionPath(act.Path) == entry {
+			return readEntry(opts)
+		}
+	}
+	return nil
+}
+
+// The entry file's current content, or nil when the check is off or the
+// entry doesn't exist yet
+func readEntry(opts ApplyOptions) []byte {
+	if entryExportMode() == "off" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(opts.filePath(entryFilePath()))
+	if err != nil {
+		return nil
+	}
+	return content
+}
+
+// Checks that an entry file the apply changed still has a default export,
+// since losing it breaks the whole app. With ENTRY_EXPORT_CHECK=rollback the
+// entry is restored to before and dropped from result.Changed; the other
+// files keep their changes. Returns nil when there is nothing to report.
+func checkEntryExport(result *ApplyResult, before []byte, opts ApplyOptions) *EntryExportIssue {
+	mode := entryExportMode()
+	entry := entryFilePath()
+	changed := -1
+	for i, path := range result.Changed {
+		if path == entry {
+			changed = i
+		}
+	}
+	if mode == "off" || changed < 0 {
+		return nil
+	}
+	content, err := ioutil.ReadFile(opts.filePath(entry))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read %s for the entry export check: %v", entry, err)
+		}
+		return nil
+	}
+	if hasDefaultExport(string(content)) {
+		return nil
+	}
+
+	issue := &EntryExportIssue{Path: entry, Issue: fmt.Sprintf("%s no longer has a default export; the app won't start", entry)}
+	log.Printf("Warning: %s", issue.Issue)
+	if mode == "rollback" && before != nil {
+		if err := ioutil.WriteFile(opts.filePath(entry), before, 0644); err != nil {
+			log.Printf("Failed to roll back %s: %v", entry, err)
+			return issue
+		}
+		log.Printf("Rolled back %s", entry)
+		issue.RolledBack = true
+		result.Changed = append(result.Changed[:changed], result.Changed[changed+1:]...)
+	}
+	return issue
+}
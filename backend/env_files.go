@@ -0,0 +1,33 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+)
+
+// Env files read at startup when ENV_FILE is unset, highest precedence first
+var defaultEnvFiles = []string{".env.local", ".env"}
+
+// Loads the env files once at startup. ENV_FILE names the files instead,
+// comma separated, e.g. "prod.env" or ".env.staging,.env". An earlier
+// file wins over a later one, and the real environment wins over both.
+// Missing default files are fine; a missing ENV_FILE entry is logged.
+func loadEnvFiles() {
+	files := envList("ENV_FILE")
+	explicit := len(files) > 0
+	if !explicit {
+		files = defaultEnvFiles
+	}
+
+	for _, file := range files {
+		if err := godotenv.Load(file); err != nil {
+			if explicit || !os.IsNotExist(err) {
+				log.Printf("Failed to load env file %s: %v", file, err)
+			}
+			continue
+		}
+		log.Printf("Loaded env file %s", file)
+	}
+}
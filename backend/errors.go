@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Stable error codes returned to clients in the JSON error body
+const (
+	errCodeInvalidRequest    = "invalid_request"
+	errCodePathRejected      = "path_rejected"
+	errCodeTooLarge          = "too_large"
+	errCodeMethodNotAllowed  = "method_not_allowed"
+	errCodeInvalidProvider   = "invalid_provider"
+	errCodeUpstream          = "upstream_error"
+	errCodeParseFailed       = "parse_failed"
+	errCodeModelRefused      = "model_refused"
+	errCodeResponseTruncated = "response_truncated"
+	errCodeRateLimited       = "rate_limited"
+	errCodeNotFound          = "not_found"
+	errCodeForbidden         = "forbidden"
+	errCodeGitFailed         = "git_failed"
+	errCodeConflict          = "conflict"
+	errCodeInternal          = "internal_error"
+)
+
+// An error with a stable code and a meaningful HTTP status
+type APIError struct {
+	Status  int
+	Code    string
+	Message string
+	Details interface{}
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, code, message string, details interface{}) *APIError {
+	return &APIError{Status: status, Code: code, Message: message, Details: details}
+}
+
+// Writes {"error": {"code", "message", "details"}} with the given status
+func writeError(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	body := map[string]interface{}{
+		"code":    code,
+		"message": message,
+	}
+	if details != nil {
+		body["details"] = details
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{"error": body})
+}
+
+// Writes err as a JSON error body. Errors that aren't an *APIError are
+// reported as internal errors.
+func writeAPIError(w http.ResponseWriter, err error) {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		writeError(w, apiErr.Status, apiErr.Code, apiErr.Message, apiErr.Details)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+}
+
+// The {"code", "message", "details"} object for err, for embedding in a
+// larger response such as a batch step
+func apiErrorBody(err error) map[string]interface{} {
+	apiErr := newAPIError(http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+	errors.As(err, &apiErr)
+
+	body := map[string]interface{}{
+		"code":    apiErr.Code,
+		"message": apiErr.Message,
+	}
+	if apiErr.Details != nil {
+		body["details"] = apiErr.Details
+	}
+	return body
+}
+
+func writeMethodNotAllowed(w http.ResponseWriter, allowed string) {
+	writeError(w, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, "Only "+allowed+" allowed", nil)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Values of EditRequest.Mode
+const (
+	modeEdit    = "edit"
+	modeExplain = "explain"
+)
+
+// Answers an "explain" request: the model describes the code in plain
+// language and nothing is written
+func serveExplain(w http.ResponseWriter, r *http.Request, req EditRequest) {
+	ctx, tracker := startProgress(r, req)
+	ctx, span := tracer.Start(ctx, "explain", trace.WithAttributes(requestAttributes(req)...))
+	defer span.End()
+
+	req.Model = resolveModelAlias(req.Model)
+	explanation, usage, err := generateExplanation(ctx, req)
+	tracker.finish(err)
+	if err != nil {
+		recordSpanError(span, err)
+		writeAPIError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":      "success",
+		"mode":        modeExplain,
+		"explanation": explanation,
+		"provider":    req.Provider,
+		"model":       req.Model,
+	}
+	if usage != nil {
+		response["usage"] = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Gathers the project context and asks the provider for an explanation
+func generateExplanation(ctx context.Context, req EditRequest) (string, *TokenUsage, error) {
+	filesJSON, _, omitted, err := gatherPromptContext(ctx, req.Instructions, req.TargetFiles)
+	if err != nil {
+		return "", nil, err
+	}
+
+	instructions, err := expandInstructions(req)
+	if err != nil {
+		return "", nil, err
+	}
+	aiResponse, usage, err := callProvider(ctx, req, buildExplainPrompt(instructions, filesJSON, omitted, projectLanguage(req)))
+	if err != nil {
+		return "", nil, err
+	}
+
+	explanation := strings.TrimSpace(stripThinking(aiResponse))
+	lastResponses.record(req.SessionID, &LastResponse{
+		Provider:   req.Provider,
+		Model:      req.Model,
+		Raw:        aiResponse,
+		Cleaned:    explanation,
+		Parsed:     true,
+		ReceivedAt: time.Now(),
+	})
+	if explanation == "" {
+		return "", nil, newAPIError(http.StatusBadGateway, errCodeUpstream, "The model returned an empty explanation", nil)
+	}
+	return explanation, usage, nil
+}
+
+// Builds the prompt asking for a plain-language explanation instead of edits
+func buildExplainPrompt(instructions string, filesJSON string, omitted []string, language string) string {
+	return fmt.Sprintf(`You are a helpful AI programming assistant explaining a React + %s project.
+
+CURRENT PROJECT STRUCTURE:
+%s
+
+Do NOT change any code and do NOT return JSON. Answer the user's question
+about the project in plain language.
+
+IMPORTANT INSTRUCTIONS:
+- Focus on the files relevant to the question and refer to them by path, e.g. "src/App.tsx".
+- Explain what the code does and how the pieces fit together; quote short snippets only where they help.
+- Use Markdown for structure. Keep it concise.
+- If the question can't be answered from the files, say so instead of guessing.
+
+User question:
+%s
+
+Project files (JSON array):
+%s
+`, languageName(language), extractFileStructure(filesJSON)+omittedFilesNote(omitted)+referenceDocsSection(), instructions, filesJSON)
+}
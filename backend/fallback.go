@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+// A provider and model to send the prompt to
+type ProviderChoice struct {
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// Fallback providers for a request: the request's own list, or else
+// FALLBACK_PROVIDERS as comma-separated "provider:model" entries,
+// e.g. "ollama:llama3:8b,openrouter:openai/gpt-4o-mini"
+func fallbackChain(req EditRequest) []ProviderChoice {
+	if len(req.Fallbacks) > 0 {
+		return req.Fallbacks
+	}
+
+	var chain []ProviderChoice
+	for _, entry := range envList("FALLBACK_PROVIDERS") {
+		provider, model, ok := strings.Cut(entry, ":")
+		if !ok || provider == "" || model == "" {
+			log.Printf("Ignoring FALLBACK_PROVIDERS entry %q (want provider:model)", entry)
+			continue
+		}
+		chain = append(chain, ProviderChoice{Provider: strings.TrimSpace(provider), Model: strings.TrimSpace(model)})
+	}
+	return chain
+}
+
+// Reports whether a generation error is worth retrying with another
+// provider: upstream failures, unparseable or truncated replies and refusals
+func shouldFallBack(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.Code {
+	case errCodeUpstream, errCodeParseFailed, errCodeModelRefused, errCodeResponseTruncated:
+		return true
+	}
+	return false
+}
+
+// Makes call with the request's provider, then with each fallback in turn
+// while its error is worth falling back on, rebuilding the prompt for each.
+// Returns the request and prompt of the last attempt, which name the
+// provider and model that answered.
+func withFallbacks(req EditRequest, prompt *EditPrompt, call func(EditRequest, *EditPrompt) error) (EditRequest, *EditPrompt, error) {
+	err := call(req, prompt)
+	for _, fallback := range fallbackChain(req) {
+		if err == nil || !shouldFallBack(err) {
+			break
+		}
+		log.Printf("%s/%s failed (%v), falling back to %s/%s", req.Provider, req.Model, err, fallback.Provider, fallback.Model)
+		req.Provider = fallback.Provider
+		req.Model = resolveModelAlias(fallback.Model)
+		prompt = prompt.forProvider(req.Provider, req.Model)
+		err = call(req, prompt)
+	}
+	return req, prompt, err
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of project file, each with its own conventions
+const (
+	kindComponent = "react-component"
+	kindScript    = "script"
+	kindStyle     = "style"
+	kindHTML      = "html"
+	kindConfig    = "config"
+)
+
+// The conventions the prompt spells out for each kind, in prompt order
+var fileKindRules = []struct {
+	kind string
+	rule string
+}{
+	{kindComponent, "react-component: React function components in JSX/TSX; imports at the top, one default-exported component per file."},
+	{kindScript, "script: plain TypeScript/JavaScript modules (hooks, utilities, types); no JSX unless the file already has it."},
+	{kindStyle, "style: plain CSS only (selectors, properties, @media and the like); never JSX, imports of scripts, JavaScript or // comments, use /* */."},
+	{kindHTML, "html: HTML markup only; keep the root element and the script tag that loads the app."},
+	{kindConfig, "config: valid JSON or config module syntax as the file already uses; keep existing keys unless the change needs them gone."},
+}
+
+// Detects a file's kind from its name, or "" when it has no particular
+// conventions
+func fileKind(path string) string {
+	lower := strings.ToLower(filepath.ToSlash(path))
+	base := filepath.Base(lower)
+	switch {
+	case strings.HasPrefix(base, ".env") || strings.Contains(base, ".config.") || strings.HasSuffix(base, ".json"):
+		return kindConfig
+	case matchesFileType(lower, []string{".css", ".scss", ".sass", ".less"}):
+		return kindStyle
+	case matchesFileType(lower, []string{".html", ".htm"}):
+		return kindHTML
+	case matchesFileType(lower, []string{".tsx", ".jsx"}):
+		return kindComponent
+	case matchesFileType(lower, scriptExtensions):
+		return kindScript
+	}
+	return ""
+}
+
+// Tells the model to keep each kind of file in the context to its own
+// conventions, so e.g. CSS doesn't pick up TSX syntax. Only kinds present
+// in filesJSON are listed, and only when there is more than one.
+func fileKindsNote(filesJSON string) string {
+	var files []FileJSON
+	if err := json.Unmarshal([]byte(filesJSON), &files); err != nil {
+		return ""
+	}
+	present := map[string]bool{}
+	for _, file := range files {
+		if file.Kind != "" {
+			present[file.Kind] = true
+		}
+	}
+	if len(present) < 2 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\nFILE KINDS: input files carry a \"kind\". Follow the conventions of each file's kind and never mix them:\n")
+	for _, kind := range fileKindRules {
+		if present[kind.kind] {
+			b.WriteString("- " + kind.rule + "\n")
+		}
+	}
+	return b.String()
+}
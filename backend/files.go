@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// A file under src/ as listed by /api/files
+type ProjectFile struct {
+	Path string `json:"path"` // "src/..." path, as used in actions
+	Size int64  `json:"size"`
+}
+
+// A node of the /api/files?tree=true tree. Every directory is included,
+// even an empty one (which has no children); files have a size.
+type FileTreeNode struct {
+	Name     string          `json:"name"`
+	Path     string          `json:"path"`
+	Type     string          `json:"type"` // "directory" or "file"
+	Size     int64           `json:"size,omitempty"`
+	Children []*FileTreeNode `json:"children,omitempty"`
+}
+
+// Lists the files under src/, flat by default or as a tree of directories
+// with ?tree=true so a UI can render it collapsible.
+// GET /api/files
+func handleFiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+
+	root := &FileTreeNode{Name: "src", Path: "src", Type: "directory"}
+	dirs := map[string]*FileTreeNode{".": root}
+	files := []ProjectFile{}
+	err := filepath.WalkDir(projectRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(projectRoot, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() && sandboxSkipDirs[d.Name()] {
+			return filepath.SkipDir
+		}
+
+		node := &FileTreeNode{Name: d.Name(), Path: "src/" + rel, Type: "file"}
+		if d.IsDir() {
+			node.Type = "directory"
+			dirs[rel] = node
+		} else {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			node.Size = info.Size()
+			files = append(files, ProjectFile{Path: node.Path, Size: node.Size})
+		}
+		// WalkDir visits a directory before its contents
+		parent := dirs[path.Dir(rel)]
+		parent.Children = append(parent.Children, node)
+		return nil
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("tree") == "true" {
+		sortFileTree(root)
+		json.NewEncoder(w).Encode(map[string]interface{}{"tree": root})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"files": files})
+}
+
+// Orders every directory's children directories first, then by name
+func sortFileTree(node *FileTreeNode) {
+	sort.Slice(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if a.Type != b.Type {
+			return a.Type == "directory"
+		}
+		return a.Name < b.Name
+	})
+	for _, child := range node.Children {
+		if child.Type == "directory" {
+			sortFileTree(child)
+		}
+	}
+}
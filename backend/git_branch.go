@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default prefix for the branches GIT_BRANCH_PER_EDIT creates
+const defaultEditBranchPrefix = "ai-edit/"
+
+// Serializes the short git steps that add, commit on and remove the edit
+// worktrees
+var editBranchMu sync.Mutex
+
+type editBranchContextKey struct{}
+
+// Branch an edit was applied on with GIT_BRANCH_PER_EDIT, reported as
+// "branch" in the response. The branch is checked out in a worktree of its
+// own, outside the project, and the edits are written and committed there:
+// the user's checkout, its branch and its uncommitted changes are never
+// touched. All methods are safe on nil, which is what edits get when the
+// mode is off.
+type EditBranch struct {
+	Name    string   `json:"name"`
+	Base    string   `json:"base"`    // branch (or commit) checked out when the edit started
+	Commits []string `json:"commits"` // one per committed edit, oldest first
+	// Last commit failure; the files are still written to the worktree
+	Error string `json:"error,omitempty"`
+
+	dir     string          // the worktree
+	root    string          // the worktree's copy of projectRoot
+	changed map[string]bool // "src/..." paths committed so far
+}
+
+// Creates a new branch from the current HEAD and checks it out in a
+// temporary worktree when GIT_BRANCH_PER_EDIT is on. Returns ctx carrying
+// the branch, for later batch steps, and nil for the branch when the mode
+// is off. finish removes the worktree again.
+func startEditBranch(ctx context.Context) (context.Context, *EditBranch, error) {
+	if !envBool("GIT_BRANCH_PER_EDIT", false) {
+		return ctx, nil, nil
+	}
+
+	head, err := runGit(ctx, "rev-parse", "--verify", "HEAD^{commit}")
+	if err != nil {
+		return ctx, nil, gitAPIError("GIT_BRANCH_PER_EDIT needs the project in a git repository with at least one commit", err)
+	}
+	base, err := runGit(ctx, "symbolic-ref", "--short", "-q", "HEAD")
+	if err != nil {
+		// Detached HEAD
+		base = head
+	}
+	// Where projectRoot sits in the repository, e.g. "frontend/src/"
+	prefix, err := runGit(ctx, "rev-parse", "--show-prefix")
+	if err != nil {
+		return ctx, nil, gitAPIError("Failed to locate the project in its git repository", err)
+	}
+	dir, err := ioutil.TempDir("", "ai-edit-")
+	if err != nil {
+		return ctx, nil, err
+	}
+
+	branchPrefix := os.Getenv("GIT_BRANCH_PREFIX")
+	if branchPrefix == "" {
+		branchPrefix = defaultEditBranchPrefix
+	}
+	now := time.Now()
+	b := &EditBranch{
+		Name:    fmt.Sprintf("%s%s-%04x", branchPrefix, now.Format("20060102-150405"), now.UnixNano()&0xffff),
+		Base:    strings.TrimSpace(base),
+		Commits: []string{},
+		dir:     dir,
+		root:    filepath.Join(dir, filepath.FromSlash(strings.TrimSpace(prefix))),
+		changed: map[string]bool{},
+	}
+
+	editBranchMu.Lock()
+	_, err = runGit(ctx, "worktree", "add", "-q", "-b", b.Name, dir, strings.TrimSpace(head))
+	editBranchMu.Unlock()
+	if err != nil {
+		os.RemoveAll(dir)
+		return ctx, nil, gitAPIError("Failed to create branch "+b.Name, err)
+	}
+	log.Printf("Applying edit on new branch %s (from %s) in %s", b.Name, b.Base, dir)
+	return context.WithValue(ctx, editBranchContextKey{}, b), b, nil
+}
+
+// The branch carried by ctx, or nil
+func editBranchFrom(ctx context.Context) *EditBranch {
+	b, _ := ctx.Value(editBranchContextKey{}).(*EditBranch)
+	return b
+}
+
+// Points opts at the branch's worktree instead of the project
+func (b *EditBranch) applyTo(opts *ApplyOptions) {
+	if b != nil {
+		opts.Root = b.root
+	}
+}
+
+// Replaces the context files the branch has committed changes to with the
+// branch's version, so a later batch step builds on the earlier ones
+func (b *EditBranch) overlay(files []FileJSON) []FileJSON {
+	if b == nil || len(b.changed) == 0 {
+		return files
+	}
+
+	kept := files[:0]
+	for _, file := range files {
+		if !b.changed[contextFileKey(file)] {
+			kept = append(kept, file)
+		}
+	}
+	opts := ApplyOptions{Root: b.root}
+	types := contextExtensions()
+	for path := range b.changed {
+		content, err := ioutil.ReadFile(opts.filePath(path))
+		if err != nil || !matchesFileType(path, types) {
+			continue // deleted on the branch
+		}
+		kept = append(kept, FileJSON{
+			Path:    strings.TrimPrefix(path, "src/"),
+			Content: string(content),
+			Hash:    contentHash(content),
+			Kind:    fileKind(strings.TrimPrefix(path, "src/")),
+		})
+	}
+	sort.Slice(kept, func(i, j int) bool {
+		return filepath.ToSlash(kept[i].Path) < filepath.ToSlash(kept[j].Path)
+	})
+	return kept
+}
+
+// Commits everything the edit wrote to the worktree. Failures are recorded
+// in Error rather than failing the edit, since the files are already
+// written.
+func (b *EditBranch) commit(ctx context.Context, changed []string, instructions string) {
+	if b == nil || len(changed) == 0 {
+		return
+	}
+	if err := b.commitWorktree(ctx, "AI edit: "+commitSubject(instructions)); err != nil {
+		b.Error = gitErrorText(err)
+		log.Printf("Failed to commit edit on %s: %s", b.Name, b.Error)
+		return
+	}
+	for _, path := range changed {
+		b.changed[path] = true
+	}
+}
+
+func (b *EditBranch) commitWorktree(ctx context.Context, message string) error {
+	editBranchMu.Lock()
+	defer editBranchMu.Unlock()
+
+	if _, err := runGitIn(ctx, b.root, "add", "-A", "--", "."); err != nil {
+		return err
+	}
+	staged, err := runGitIn(ctx, b.root, "diff", "--cached", "--name-only")
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(staged) == "" {
+		return nil // rewritten with the same content
+	}
+	if _, err := runGitIn(ctx, b.root, "commit", "-q", "-m", message); err != nil {
+		return err
+	}
+	hash, err := runGitIn(ctx, b.root, "rev-parse", "--short", "HEAD")
+	if err != nil {
+		return err
+	}
+	b.Commits = append(b.Commits, strings.TrimSpace(hash))
+	return nil
+}
+
+// Removes the worktree once the response is written. A branch without
+// commits is deleted with it.
+func (b *EditBranch) finish() {
+	if b == nil {
+		return
+	}
+	editBranchMu.Lock()
+	defer editBranchMu.Unlock()
+
+	// The request may already be cancelled; this must still run
+	ctx := context.Background()
+	if _, err := runGit(ctx, "worktree", "remove", "--force", b.dir); err != nil {
+		log.Printf("Failed to remove the worktree of %s: %s", b.Name, gitErrorText(err))
+		os.RemoveAll(b.dir)
+		runGit(ctx, "worktree", "prune")
+	}
+	if len(b.Commits) == 0 {
+		if _, err := runGit(ctx, "branch", "-D", b.Name); err != nil {
+			log.Printf("Failed to remove empty branch %s: %s", b.Name, gitErrorText(err))
+		}
+	}
+}
+
+// First line of the instructions, shortened to fit a commit subject
+func commitSubject(instructions string) string {
+	subject, _, _ := strings.Cut(strings.TrimSpace(instructions), "\n")
+	if subject == "" {
+		return "apply actions"
+	}
+	if len(subject) > 60 {
+		subject = strings.ToValidUTF8(subject[:60], "") + "..."
+	}
+	return subject
+}
+
+// The stderr git printed for a failed command, or the error itself
+func gitErrorText(err error) string {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return strings.TrimSpace(string(exitErr.Stderr))
+	}
+	return err.Error()
+}
+
+func gitAPIError(message string, err error) *APIError {
+	return newAPIError(http.StatusConflict, errCodeGitFailed, message, map[string]string{"git": gitErrorText(err)})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Runs git in dir, failing the test on error
+func gitInTest(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+func TestEditOnBranch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	const app = "export default function App() {\n  return <h1>Hello</h1>;\n}\n"
+	const unsaved = "export default function App() {\n  return <h1>Unsaved</h1>;\n}\n"
+	const button = "export const Button = () => <button>OK</button>;\n"
+
+	root := useTempProject(t, map[string]string{"App.tsx": app})
+	repo := filepath.Dir(root)
+	gitInTest(t, repo, "init", "-q")
+	gitInTest(t, repo, "add", "-A")
+	gitInTest(t, repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial")
+	t.Setenv("GIT_BRANCH_PER_EDIT", "true")
+	t.Setenv("GIT_AUTHOR_NAME", "test")
+	t.Setenv("GIT_AUTHOR_EMAIL", "test@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "test")
+	t.Setenv("GIT_COMMITTER_EMAIL", "test@example.com")
+
+	// The user has an unsaved change the edit also touches
+	if err := ioutil.WriteFile(filepath.Join(root, "App.tsx"), []byte(unsaved), 0644); err != nil {
+		t.Fatal(err)
+	}
+	before := readTree(t, root)
+	head := gitInTest(t, repo, "rev-parse", "HEAD")
+	current := gitInTest(t, repo, "symbolic-ref", "--short", "HEAD")
+
+	reply, _ := json.Marshal(AIEditActions{Actions: []EditAction{
+		{Type: "create", Path: "src/components/Button.tsx", Content: button},
+		{Type: "update", Path: "src/App.tsx", Content: "export {};\n"},
+	}})
+	status, response := postMockEdit(t, string(reply), true)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200; response %v", status, response)
+	}
+
+	if got := readTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("the checkout changed: %q, want %q", got, before)
+	}
+	if got := gitInTest(t, repo, "rev-parse", "HEAD"); got != head {
+		t.Errorf("HEAD moved to %s, want %s", got, head)
+	}
+	if got := gitInTest(t, repo, "symbolic-ref", "--short", "HEAD"); got != current {
+		t.Errorf("checked out %s, want %s", got, current)
+	}
+	if got := gitInTest(t, repo, "worktree", "list", "--porcelain"); strings.Count(got, "worktree ") != 1 {
+		t.Errorf("the edit's worktree was left behind:\n%s", got)
+	}
+
+	branch, _ := response["branch"].(map[string]interface{})
+	name, _ := branch["name"].(string)
+	if name == "" {
+		t.Fatalf("response has no branch: %v", response)
+	}
+	if got := gitInTest(t, repo, "show", name+":src/components/Button.tsx"); got != strings.TrimSpace(button) {
+		t.Errorf("Button.tsx on %s = %q, want %q", name, got, button)
+	}
+	if got := gitInTest(t, repo, "show", name+":src/App.tsx"); got != strings.TrimSpace(app) {
+		t.Errorf("App.tsx on %s = %q, want the committed version", name, got)
+	}
+	if got := skippedPaths(response); !reflect.DeepEqual(got, []string{"src/App.tsx"}) {
+		t.Errorf("skipped = %v, want the file with unsaved changes", got)
+	}
+}
+
+func TestEditBranchWithoutChanges(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := useTempProject(t, map[string]string{"App.tsx": "export default function App() { return null; }\n"})
+	repo := filepath.Dir(root)
+	gitInTest(t, repo, "init", "-q")
+	gitInTest(t, repo, "add", "-A")
+	gitInTest(t, repo, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial")
+	t.Setenv("GIT_BRANCH_PER_EDIT", "true")
+
+	_, branch, err := startEditBranch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	branch.finish()
+	if got := gitInTest(t, repo, "branch", "--list", branch.Name); got != "" {
+		t.Errorf("branch %s without commits was kept", branch.Name)
+	}
+}
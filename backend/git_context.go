@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Defaults for the GIT_CONTEXT history section
+const (
+	defaultGitLogCount     = 5
+	defaultGitDiffMaxBytes = 20 << 10
+	gitCommandTimeout      = 5 * time.Second
+)
+
+// Builds the history section for the prompt when GIT_CONTEXT is on: the
+// last GIT_LOG_COUNT commit subjects touching the project and its
+// uncommitted git diff, capped at GIT_DIFF_MAX_BYTES. Returns "" when the
+// project isn't in a git repository or git isn't installed.
+func gitHistory(ctx context.Context) string {
+	if !envBool("GIT_CONTEXT", false) {
+		return ""
+	}
+	if _, err := runGit(ctx, "rev-parse", "--is-inside-work-tree"); err != nil {
+		log.Printf("GIT_CONTEXT is on but %s is not in a git repository: %v", projectRoot, err)
+		return ""
+	}
+
+	var b strings.Builder
+	if count := envInt("GIT_LOG_COUNT", defaultGitLogCount); count > 0 {
+		commits, err := runGit(ctx, "log", fmt.Sprintf("-n%d", count), "--format=%h %ad %s", "--date=short", "--", ".")
+		if err != nil {
+			log.Printf("Failed to read git log: %v", err)
+		} else if commits = strings.TrimSpace(commits); commits != "" {
+			b.WriteString("Recent commits (newest first):\n" + commits + "\n")
+		}
+	}
+
+	diff, err := runGit(ctx, "diff", "HEAD", "--", ".")
+	if err != nil {
+		// No HEAD yet in a fresh repository
+		diff, err = runGit(ctx, "diff", "--", ".")
+	}
+	if err != nil {
+		log.Printf("Failed to read git diff: %v", err)
+	} else if diff = strings.TrimSpace(diff); diff != "" {
+		if limit := envInt("GIT_DIFF_MAX_BYTES", defaultGitDiffMaxBytes); limit > 0 && len(diff) > limit {
+			diff = strings.ToValidUTF8(diff[:limit], "") + "\n[diff truncated]"
+		}
+		b.WriteString("Uncommitted changes (git diff):\n" + diff + "\n")
+	}
+
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\nRECENT PROJECT HISTORY (for context only; do not undo these changes unless the user asks):\n" + b.String()
+}
+
+// Runs git in projectRoot and returns its output
+func runGit(ctx context.Context, args ...string) (string, error) {
+	return runGitIn(ctx, projectRoot, args...)
+}
+
+// Like runGit, in another directory such as an edit branch's worktree
+func runGitIn(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, gitCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	output, err := cmd.Output()
+	return string(output), err
+}
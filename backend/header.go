@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// Returns the license/header comment at the top of a source file, or "" when
+// it has none. HEADER_TEMPLATE pins the exact header text; otherwise the
+// leading /* */ or // comment block counts as a header when it mentions one of
+// HEADER_MARKERS (default "license,copyright,spdx-license-identifier").
+func extractHeader(content string) string {
+	if template := os.Getenv("HEADER_TEMPLATE"); template != "" {
+		template = strings.TrimSpace(template)
+		if strings.HasPrefix(strings.TrimSpace(content), template) {
+			return template
+		}
+		return ""
+	}
+
+	trimmed := strings.TrimLeft(content, " \t\r\n")
+	var block string
+	switch {
+	case strings.HasPrefix(trimmed, "/*"):
+		end := strings.Index(trimmed, "*/")
+		if end < 0 {
+			return ""
+		}
+		block = trimmed[:end+2]
+	case strings.HasPrefix(trimmed, "//"):
+		var lines []string
+		for _, line := range strings.Split(trimmed, "\n") {
+			if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+				break
+			}
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
+		block = strings.Join(lines, "\n")
+	default:
+		return ""
+	}
+
+	markers := envList("HEADER_MARKERS")
+	if len(markers) == 0 {
+		markers = []string{"license", "copyright", "spdx-license-identifier"}
+	}
+	lower := strings.ToLower(block)
+	for _, marker := range markers {
+		if strings.Contains(lower, strings.ToLower(marker)) {
+			return block
+		}
+	}
+	return ""
+}
+
+// Re-prepends the existing file's header when the new content dropped it.
+// Reports whether the header had to be restored.
+func preserveHeader(existing, content string) (string, bool) {
+	header := extractHeader(existing)
+	if header == "" || strings.Contains(content, header) {
+		return content, false
+	}
+	return header + "\n" + strings.TrimLeft(content, "\r\n"), true
+}
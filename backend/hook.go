@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Outcome of running the post-edit hook command
+type HookResult struct {
+	Command  string `json:"command"`
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Runs POST_EDIT_HOOK after a successful edit batch, if enabled.
+//
+// Running arbitrary commands is disabled unless ENABLE_POST_EDIT_HOOK is true.
+// The command is split on whitespace and executed directly (no shell), with
+// the changed paths appended as arguments and also passed newline separated
+// in the EDITED_FILES environment variable. Paths come from the model, so the
+// hook must treat them as untrusted input. It runs in dir, the frontend
+// folder the edits were written to.
+func runPostEditHook(changed []string, dir string) *HookResult {
+	hook := strings.TrimSpace(os.Getenv("POST_EDIT_HOOK"))
+	if hook == "" || !envBool("ENABLE_POST_EDIT_HOOK", false) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), envDuration("POST_EDIT_HOOK_TIMEOUT", time.Minute))
+	defer cancel()
+
+	fields := strings.Fields(hook)
+	cmd := exec.CommandContext(ctx, fields[0], append(fields[1:], changed...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "EDITED_FILES="+strings.Join(changed, "\n"))
+
+	output, err := cmd.CombinedOutput()
+	result := &HookResult{
+		Command: hook,
+		Output:  string(output),
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.ExitCode = -1
+		}
+		result.Error = err.Error()
+		log.Printf("Post-edit hook failed: %v", err)
+	}
+	return result
+}
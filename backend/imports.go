@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Module specifiers in import/export-from statements, side-effect imports,
+// dynamic import() and require() calls
+var importSourcePattern = regexp.MustCompile(`(?m)(?:\bfrom\s*|^\s*import\s*|\bimport\s*\(\s*|\brequire\s*\(\s*)["']([^"'\n]+)["']`)
+
+// File types whose imports are checked
+var scriptExtensions = []string{".tsx", ".ts", ".jsx", ".js", ".mjs", ".cjs"}
+
+// Returns the module specifiers imported by a script, in order of appearance
+func importSources(content string) []string {
+	var sources []string
+	for _, match := range importSourcePattern.FindAllStringSubmatch(content, -1) {
+		sources = append(sources, match[1])
+	}
+	return sources
+}
+
+// Returns the first import in content that FORBIDDEN_IMPORTS bans, or "".
+// An entry bans the package and its subpaths: "lodash" also covers "lodash/get".
+func forbiddenImport(path, content string) string {
+	denied := envList("FORBIDDEN_IMPORTS")
+	if len(denied) == 0 || !matchesFileType(path, scriptExtensions) {
+		return ""
+	}
+	for _, source := range importSources(content) {
+		for _, banned := range denied {
+			if source == banned || strings.HasPrefix(source, banned+"/") {
+				return source
+			}
+		}
+	}
+	return ""
+}
+
+// Extensions tried, in order, for an import without one, as the bundler does
+var importResolveExtensions = []string{".tsx", ".ts", ".jsx", ".js", ".json"}
+
+// The compilerOptions of tsconfig.json that affect module resolution
+type tsconfigPaths struct {
+	BaseURL string              `json:"baseUrl"`
+	Paths   map[string][]string `json:"paths"`
+}
+
+// Reads baseUrl and paths from the tsconfig.json in frontendRoot. Comments
+// are allowed as tsconfig allows them; a missing or unreadable file yields
+// no aliases.
+func loadTsconfigPaths(frontendRoot string) (tsconfigPaths, string) {
+	var config struct {
+		CompilerOptions tsconfigPaths `json:"compilerOptions"`
+	}
+	data, err := ioutil.ReadFile(filepath.Join(frontendRoot, "tsconfig.json"))
+	if err == nil {
+		if err := json.Unmarshal([]byte(stripJSONComments(string(data))), &config); err != nil {
+			log.Printf("Ignoring tsconfig.json paths: %v", err)
+		}
+	}
+	return config.CompilerOptions, filepath.Join(frontendRoot, config.CompilerOptions.BaseURL)
+}
+
+// Checks the relative and alias imports of the changed script files against
+// the files on disk and the tsconfig paths, and lists the ones that don't
+// resolve. Package imports are not checked. opts says where the files were
+// written.
+func unresolvedImports(changed []string, opts ApplyOptions) []FileIssue {
+	frontendRoot := filepath.Dir(opts.rootDir())
+	tsconfig, baseDir := loadTsconfigPaths(frontendRoot)
+	publicDir := filepath.Join(frontendRoot, "public")
+
+	var issues []FileIssue
+	for _, path := range changed {
+		if !matchesFileType(path, scriptExtensions) {
+			continue
+		}
+		fullPath := opts.filePath(path)
+		content, err := ioutil.ReadFile(fullPath)
+		if err != nil {
+			continue // deleted in this batch
+		}
+
+		for _, source := range importSources(string(content)) {
+			var candidates []string
+			switch {
+			case strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") || source == "." || source == "..":
+				candidates = []string{filepath.Join(filepath.Dir(fullPath), filepath.FromSlash(source))}
+			case strings.HasPrefix(source, "/"):
+				// Vite serves absolute imports such as "/vite.svg" from public/
+				candidates = []string{filepath.Join(publicDir, filepath.FromSlash(source))}
+			default:
+				var aliased bool
+				candidates, aliased = aliasCandidates(source, tsconfig, baseDir)
+				if !aliased {
+					if !strings.HasPrefix(source, "@/") && !strings.HasPrefix(source, "~/") {
+						continue // a package
+					}
+					// Looks like a path alias, but tsconfig doesn't define it
+				}
+			}
+
+			if !resolvesToFile(candidates) {
+				issues = append(issues, FileIssue{Path: path, Issue: fmt.Sprintf("cannot resolve import %q", source)})
+			}
+		}
+	}
+	return issues
+}
+
+// Files an import may refer to through tsconfig "paths" (e.g. "@/*" ->
+// "src/*"), or through baseUrl when it is set. Reports whether any alias
+// applied.
+func aliasCandidates(source string, tsconfig tsconfigPaths, baseDir string) ([]string, bool) {
+	var candidates []string
+	for pattern, targets := range tsconfig.Paths {
+		prefix, hasWildcard := strings.CutSuffix(pattern, "*")
+		var rest string
+		switch {
+		case hasWildcard && strings.HasPrefix(source, prefix):
+			rest = strings.TrimPrefix(source, prefix)
+		case !hasWildcard && source == pattern:
+		default:
+			continue
+		}
+		for _, target := range targets {
+			candidates = append(candidates, filepath.Join(baseDir, filepath.FromSlash(strings.Replace(target, "*", rest, 1))))
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates, true
+	}
+	if tsconfig.BaseURL != "" {
+		// Non-relative imports may resolve from baseUrl, otherwise they're packages
+		candidate := filepath.Join(baseDir, filepath.FromSlash(source))
+		if resolvesToFile([]string{candidate}) {
+			return []string{candidate}, true
+		}
+	}
+	return nil, false
+}
+
+// Reports whether any candidate exists as a file, directly, with one of
+// importResolveExtensions, or as a directory index
+func resolvesToFile(candidates []string) bool {
+	for _, candidate := range candidates {
+		tries := []string{candidate}
+		for _, ext := range importResolveExtensions {
+			tries = append(tries, candidate+ext, filepath.Join(candidate, "index"+ext))
+		}
+		for _, try := range tries {
+			if info, err := os.Stat(try); err == nil && !info.IsDir() {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Largest instruction file that may be inlined into a prompt
+const maxInstructionFileBytes = 256 << 10
+
+// Returns the request's instructions ready for the prompt: a leading
+// "@file.md" reference is replaced by that file from INSTRUCTIONS_DIR (any
+// text after the reference is kept after it), {{key}} variables are
+// substituted, and the team-wide INSTRUCTION_PREFIX and INSTRUCTION_SUFFIX
+// are wrapped around the result. References that would leave the
+// directory are returned as path_rejected errors, other bad references as
+// invalid_request.
+func expandInstructions(req EditRequest) (string, error) {
+	instructions, err := inlineInstructionFile(req.Instructions)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			return "", err
+		}
+		return "", newAPIError(http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+	}
+	instructions = substituteVariables(instructions, req.Variables)
+
+	if prefix := strings.TrimSpace(os.Getenv("INSTRUCTION_PREFIX")); prefix != "" {
+		instructions = prefix + "\n\n" + instructions
+	}
+	if suffix := strings.TrimSpace(os.Getenv("INSTRUCTION_SUFFIX")); suffix != "" {
+		instructions = instructions + "\n\n" + suffix
+	}
+	return instructions, nil
+}
+
+func inlineInstructionFile(instructions string) (string, error) {
+	trimmed := strings.TrimSpace(instructions)
+	if !strings.HasPrefix(trimmed, "@") {
+		return instructions, nil
+	}
+
+	dir := os.Getenv("INSTRUCTIONS_DIR")
+	if dir == "" {
+		// References only work when a directory is configured
+		return instructions, nil
+	}
+
+	ref, rest := trimmed[1:], ""
+	if i := strings.IndexAny(ref, " \t\r\n"); i >= 0 {
+		ref, rest = ref[:i], strings.TrimSpace(ref[i:])
+	}
+
+	path, err := instructionFilePath(dir, ref)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("instruction file %q not found", ref)
+	}
+	if info.Size() > maxInstructionFileBytes {
+		return "", fmt.Errorf("instruction file %q is over the %d byte limit", ref, maxInstructionFileBytes)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read instruction file %q: %v", ref, err)
+	}
+
+	if rest != "" {
+		return string(content) + "\n\n" + rest, nil
+	}
+	return string(content), nil
+}
+
+// Resolves a reference inside the instructions directory, rejecting any
+// reference (including through symlinks) that would end up outside it
+func instructionFilePath(dir, ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("invalid instruction file reference %q", ref)
+	}
+	if filepath.IsAbs(ref) || strings.Contains(ref, ":") {
+		return "", newAPIError(http.StatusBadRequest, errCodePathRejected, fmt.Sprintf("instruction file reference %q must be relative to INSTRUCTIONS_DIR", ref), nil)
+	}
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", fmt.Errorf("INSTRUCTIONS_DIR is not readable: %v", err)
+	}
+	path, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(ref)))
+	if err != nil {
+		return "", fmt.Errorf("instruction file %q not found", ref)
+	}
+
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", newAPIError(http.StatusBadRequest, errCodePathRejected, fmt.Sprintf("instruction file %q is outside INSTRUCTIONS_DIR", ref), nil)
+	}
+	return path, nil
+}
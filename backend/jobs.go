@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of a background edit job
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// How long finished jobs stay available unless JOB_RETENTION says otherwise
+const defaultJobRetention = time.Hour
+
+// An edit started with POST /api/edit?async=true, as returned by /api/jobs/<id>
+type Job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// What /api/edit would have answered, once the job has finished
+	HTTPStatus int             `json:"http_status,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+
+	request     EditRequest
+	httpRequest *http.Request
+}
+
+type jobStore struct {
+	mu    sync.Mutex
+	jobs  map[string]*Job
+	queue chan *Job
+	once  sync.Once
+}
+
+var editJobs = &jobStore{jobs: map[string]*Job{}}
+
+// Queues the edit to run in the background and answers 202 with the job ID
+// at once. The job keeps running when the client disconnects.
+func enqueueEdit(w http.ResponseWriter, r *http.Request, req EditRequest) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	job := &Job{
+		ID:        hex.EncodeToString(idBytes),
+		Status:    jobQueued,
+		CreatedAt: time.Now(),
+		request:   req,
+		// Detached from the connection, which closes with this response
+		httpRequest: r.Clone(context.Background()),
+	}
+
+	if !editJobs.add(job) {
+		writeError(w, http.StatusServiceUnavailable, errCodeRateLimited, "The job queue is full; try again later", nil)
+		return
+	}
+	log.Printf("Queued edit job %s", job.ID)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":     jobQueued,
+		"job_id":     job.ID,
+		"status_url": "/api/jobs/" + job.ID,
+	})
+}
+
+// Stores a job and queues it, starting the JOB_WORKERS workers (default 2)
+// on first use. Returns false when JOB_QUEUE_SIZE jobs are already waiting.
+func (s *jobStore) add(job *Job) bool {
+	s.once.Do(func() {
+		workers := envInt("JOB_WORKERS", 2)
+		if workers < 1 {
+			workers = 1
+		}
+		s.queue = make(chan *Job, envInt("JOB_QUEUE_SIZE", 100))
+		for i := 0; i < workers; i++ {
+			go s.work()
+		}
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget jobs that finished a while ago
+	retention := envDuration("JOB_RETENTION", defaultJobRetention)
+	for id, old := range s.jobs {
+		if old.FinishedAt != nil && time.Since(*old.FinishedAt) > retention {
+			delete(s.jobs, id)
+		}
+	}
+
+	select {
+	case s.queue <- job:
+	default:
+		return false
+	}
+	s.jobs[job.ID] = job
+	return true
+}
+
+func (s *jobStore) work() {
+	for job := range s.queue {
+		s.run(job)
+	}
+}
+
+// Runs the edit exactly as /api/edit would and stores its response
+func (s *jobStore) run(job *Job) {
+	s.update(job, func() {
+		now := time.Now()
+		job.Status, job.StartedAt = jobRunning, &now
+	})
+
+	response := &jobResponse{header: http.Header{}}
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				log.Printf("Edit job %s panicked: %v", job.ID, recovered)
+				response = &jobResponse{header: http.Header{}}
+				writeError(response, http.StatusInternalServerError, errCodeInternal, "The edit job failed unexpectedly", nil)
+			}
+		}()
+		serveEdit(response, job.httpRequest, job.request)
+	}()
+
+	s.update(job, func() {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.HTTPStatus = response.statusCode()
+		job.Result = json.RawMessage(bytes.TrimSpace(response.body.Bytes()))
+		job.Status = jobDone
+		if job.HTTPStatus >= http.StatusBadRequest {
+			job.Status = jobFailed
+		}
+	})
+	log.Printf("Edit job %s %s (%d)", job.ID, job.Status, job.HTTPStatus)
+}
+
+func (s *jobStore) update(job *Job, change func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change()
+}
+
+// A copy of the job taken under the lock, safe to encode
+func (s *jobStore) get(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Returns the status of an edit job, and its result once finished.
+// GET /api/jobs/<id>
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Job id is required: /api/jobs/<id>", nil)
+		return
+	}
+	job, ok := editJobs.get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No job with this id; it may have expired", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// Collects the response a job's edit writes
+type jobResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *jobResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *jobResponse) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *jobResponse) Write(data []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(data)
+}
+
+func (r *jobResponse) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
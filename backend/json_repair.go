@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// <think>...</think> blocks that reasoning models such as deepseek-reasoner
+// and DeepSeek-R1 put before their answer. An unclosed block runs to the end.
+var thinkingBlockPattern = regexp.MustCompile(`(?s)<think(?:ing)?>.*?(?:</think(?:ing)?>|\z)`)
+
+// Detects replies that contain no actions object at all, which usually means
+// the model refused or answered in prose. Returns a model_refused error
+// carrying the model's own words, or nil when the reply looks like JSON.
+func refusalError(response string) error {
+	response = stripThinking(response)
+	open := strings.Index(response, "{")
+	key := strings.Index(response, `"actions"`)
+	if open >= 0 && key > open {
+		return nil
+	}
+
+	message := strings.TrimSpace(response)
+	if message == "" {
+		message = "(empty response)"
+	}
+	return newAPIError(http.StatusUnprocessableEntity, errCodeModelRefused,
+		"The model did not return any edit actions: "+message,
+		map[string]string{"model_message": message})
+}
+
+// Removes // line comments and /* */ block comments from JSON text, leaving
+// anything inside string literals untouched
+func stripJSONComments(text string) string {
+	var out strings.Builder
+	out.Grow(len(text))
+
+	inString := false
+	escaped := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		if inString {
+			out.WriteByte(c)
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		if c == '/' && i+1 < len(text) {
+			switch text[i+1] {
+			case '/':
+				// Skip to the end of the line, keeping the newline itself
+				for i+1 < len(text) && text[i+1] != '\n' {
+					i++
+				}
+				continue
+			case '*':
+				end := strings.Index(text[i+2:], "*/")
+				if end < 0 {
+					return out.String()
+				}
+				i += 2 + end + 1
+				continue
+			}
+		}
+
+		if c == '"' {
+			inString = true
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// Fixes raw control characters, which JSON doesn't allow and Unmarshal
+// rejects with "invalid character '\x00' in string literal" and the like.
+// Inside strings, raw newlines, carriage returns and tabs become \n, \r
+// and \t; other control characters become \u00XX, or are dropped when strip
+// is set. Outside strings, whitespace is kept and anything else is dropped.
+// Existing escape sequences are left untouched.
+func sanitizeControlChars(text string, strip bool) string {
+	if strings.IndexFunc(text, func(r rune) bool { return r < 0x20 }) < 0 {
+		return text
+	}
+
+	var out strings.Builder
+	out.Grow(len(text))
+
+	inString := false
+	escaped := false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+
+		if c < 0x20 {
+			if !inString {
+				if c == '\n' || c == '\r' || c == '\t' {
+					out.WriteByte(c)
+				}
+				continue
+			}
+			switch {
+			case c == '\n':
+				out.WriteString(`\n`)
+			case c == '\r':
+				out.WriteString(`\r`)
+			case c == '\t':
+				out.WriteString(`\t`)
+			case !strip:
+				fmt.Fprintf(&out, `\u%04x`, c)
+			}
+			// A control character can't complete an escape sequence
+			escaped = false
+			continue
+		}
+
+		out.WriteByte(c)
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		} else if c == '"' {
+			inString = true
+		}
+	}
+	return out.String()
+}
+
+// Detects a reply that was cut off mid-JSON, typically because the model hit
+// its output token limit: the text from the first "{" ends inside a string
+// or with unclosed braces or brackets. Returns a response_truncated error, or
+// nil when the JSON is complete (and failed to parse for another reason).
+func truncationError(response string) error {
+	response = stripThinking(response)
+	open := strings.Index(response, "{")
+	if open < 0 {
+		return nil
+	}
+
+	depth := 0
+	inString := false
+	escaped := false
+	for i := open; i < len(response); i++ {
+		c := response[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+		}
+	}
+	if !inString && depth <= 0 {
+		return nil
+	}
+
+	return newAPIError(http.StatusBadGateway, errCodeResponseTruncated,
+		"The model's response was cut off before the JSON was complete, most likely at its output token limit. "+
+			"Use a model with a larger output limit or ask for fewer files per request.",
+		map[string]interface{}{"response_bytes": len(response), "unclosed": depth, "in_string": inString})
+}
+
+// Decodes the actions object from a cleaned response. Recovers replies that
+// double-encode "actions" as a string holding the JSON array (or the whole
+// object) by decoding that string once more.
+func decodeEditActions(cleaned string) (AIEditActions, error) {
+	var edits AIEditActions
+	err := json.Unmarshal([]byte(cleaned), &edits)
+	var typeErr *json.UnmarshalTypeError
+	if err == nil || !errors.As(err, &typeErr) || typeErr.Value != "string" || typeErr.Field != "actions" {
+		return edits, err
+	}
+
+	var wrapped struct {
+		Actions string `json:"actions"`
+	}
+	if json.Unmarshal([]byte(cleaned), &wrapped) != nil {
+		return edits, err
+	}
+	inner := strings.TrimSpace(wrapped.Actions)
+	var actions []EditAction
+	if json.Unmarshal([]byte(inner), &actions) == nil {
+		log.Printf("Decoded actions sent as a JSON string")
+		return AIEditActions{Actions: actions, Message: edits.Message, Summary: edits.Summary}, nil
+	}
+	var nested AIEditActions
+	if json.Unmarshal([]byte(inner), &nested) == nil {
+		log.Printf("Decoded actions sent as a JSON string")
+		return nested, nil
+	}
+	return edits, err
+}
+
+// Returns the text inside a reply that is a single JSON string, as models
+// sometimes send the whole actions object quoted, optionally in a code
+// fence. ok is false when the reply isn't a JSON string.
+func unquoteResponse(response string) (string, bool) {
+	text := strings.TrimSpace(stripThinking(response))
+	if fenced, ok := strings.CutPrefix(text, "```"); ok {
+		// Drop the fence and its language tag
+		_, fenced, _ = strings.Cut(fenced, "\n")
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(fenced), "```"))
+	}
+	if len(text) < 2 || text[0] != '"' || text[len(text)-1] != '"' {
+		return "", false
+	}
+	var inner string
+	if json.Unmarshal([]byte(text), &inner) != nil {
+		return "", false
+	}
+	return inner, strings.Contains(inner, "{")
+}
+
+// Removes reasoning blocks so braces inside them aren't mistaken for the answer
+func stripThinking(response string) string {
+	if !strings.Contains(response, "<think") {
+		return response
+	}
+	return strings.TrimSpace(thinkingBlockPattern.ReplaceAllString(response, ""))
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStripJSONComments(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "line comment",
+			in:   "{\n  \"a\": 1 // the answer\n}",
+			want: "{\n  \"a\": 1 \n}",
+		},
+		{
+			name: "block comment",
+			in:   `{"a": /* inline */ 1}`,
+			want: `{"a":  1}`,
+		},
+		{
+			name: "multi-line block comment",
+			in:   "{\n/* first\n   second */\n\"a\": 1}",
+			want: "{\n\n\"a\": 1}",
+		},
+		{
+			name: "URL in a string",
+			in:   `{"url": "https://example.com/api"}`,
+			want: `{"url": "https://example.com/api"}`,
+		},
+		{
+			name: "comment markers in a string",
+			in:   `{"content": "// not a comment /* nor this */"}`,
+			want: `{"content": "// not a comment /* nor this */"}`,
+		},
+		{
+			name: "escaped quote before a comment marker",
+			in:   `{"content": "say \"hi\" // still text"} // real comment`,
+			want: `{"content": "say \"hi\" // still text"} `,
+		},
+		{
+			name: "escaped backslash ends the string",
+			in:   `{"path": "C:\\"} // comment`,
+			want: `{"path": "C:\\"} `,
+		},
+		{
+			name: "unterminated block comment",
+			in:   `{"a": 1} /* never closed`,
+			want: `{"a": 1} `,
+		},
+		{
+			name: "no comments",
+			in:   `{"a": [1, 2, "3 / 4"]}`,
+			want: `{"a": [1, 2, "3 / 4"]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripJSONComments(tt.in); got != tt.want {
+				t.Errorf("stripJSONComments(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanAIResponseJSONC(t *testing.T) {
+	t.Setenv("JSONC_TOLERANT", "true")
+	reply := `Here you go:
+{
+  // Update the app
+  "actions": [
+    {
+      "type": "update", /* keep the path */
+      "path": "src/App.tsx",
+      "content": "// see https://react.dev\nexport default function App() { return null; }\n"
+    }
+  ]
+}`
+	var edits AIEditActions
+	if err := json.Unmarshal([]byte(cleanAIResponse(reply)), &edits); err != nil {
+		t.Fatalf("commented reply didn't parse after cleanup: %v", err)
+	}
+	if len(edits.Actions) != 1 {
+		t.Fatalf("got %d actions, want 1", len(edits.Actions))
+	}
+	want := "// see https://react.dev\nexport default function App() { return null; }\n"
+	if got := edits.Actions[0].Content; got != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+}
+
+func TestTruncationError(t *testing.T) {
+	complete := `{"actions": [{"type": "create", "path": "src/Foo.tsx", "content": "export const a = [1, {b: 2}];\n"}]}`
+	tests := []struct {
+		name      string
+		reply     string
+		truncated bool
+	}{
+		{"complete", complete, false},
+		{"complete with braces in a string", `{"actions": [{"type": "create", "path": "src/a.ts", "content": "}}]] \"{\" ["}]}`, false},
+		{"cut mid-string", `{"actions": [{"type": "create", "path": "src/Foo.tsx", "content": "export const a = [1, {b:`, true},
+		{"cut after an escaped quote", `{"actions": [{"type": "create", "path": "src/a.ts", "content": "say \"`, true},
+		{"cut mid-array", `{"actions": [{"type": "delete", "path": "src/Old.tsx"}, `, true},
+		{"cut mid-object", `{"actions": [{"type": "delete", "path": "src/Old.tsx"`, true},
+		{"cut after a key", `{"actions"`, true},
+		{"cut inside a thinking block's answer", "<think>plan {</think>\n{\"actions\": [", true},
+		{"no JSON at all", "I can't help with that.", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := truncationError(tt.reply)
+			if !tt.truncated {
+				if err != nil {
+					t.Errorf("truncationError(%q) = %v, want nil", tt.reply, err)
+				}
+				return
+			}
+			apiErr, ok := err.(*APIError)
+			if !ok {
+				t.Fatalf("truncationError(%q) = %v, want an *APIError", tt.reply, err)
+			}
+			if apiErr.Code != errCodeResponseTruncated {
+				t.Errorf("code = %q, want %q", apiErr.Code, errCodeResponseTruncated)
+			}
+		})
+	}
+}
+
+func TestSanitizeControlChars(t *testing.T) {
+	tests := []struct {
+		name  string
+		in    string
+		strip bool
+		want  string
+	}{
+		{"clean text is unchanged", `{"a": "b\n"}`, false, `{"a": "b\n"}`},
+		{"NUL is escaped", "{\"a\": \"x\x00y\"}", false, `{"a": "x\u0000y"}`},
+		{"NUL is stripped", "{\"a\": \"x\x00y\"}", true, `{"a": "xy"}`},
+		{"bell and escape are escaped", "{\"a\": \"\x07\x1b[0m\"}", false, `{"a": "\u0007\u001b[0m"}`},
+		{"whitespace in strings becomes escapes", "{\"a\": \"1\n2\t3\r\"}", false, `{"a": "1\n2\t3\r"}`},
+		{"whitespace in strings is kept when stripping", "{\"a\": \"1\n2\t3\r\"}", true, `{"a": "1\n2\t3\r"}`},
+		{"whitespace between tokens is kept", "{\n\t\"a\": 1\r\n}", false, "{\n\t\"a\": 1\r\n}"},
+		{"other control characters between tokens are dropped", "{\x00\"a\":\x01 1}", false, `{"a": 1}`},
+		{"existing escapes are untouched", "{\"a\": \"\\\\\x01\\\"\"}", false, `{"a": "\\\u0001\""}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeControlChars(tt.in, tt.strip); got != tt.want {
+				t.Errorf("sanitizeControlChars(%q, %v) = %q, want %q", tt.in, tt.strip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCleanAIResponseControlChars(t *testing.T) {
+	reply := "{\"actions\": [{\"type\": \"create\", \"path\": \"src/a.ts\", \"content\": \"a\x00b\tc\nd\"}]}"
+	tests := []struct {
+		mode    string
+		content string // "" when the reply must still fail to parse
+	}{
+		{"", "a\x00b\tc\nd"},
+		{"escape", "a\x00b\tc\nd"},
+		{"strip", "ab\tc\nd"},
+		{"keep", ""},
+	}
+	for _, tt := range tests {
+		t.Run("mode "+tt.mode, func(t *testing.T) {
+			t.Setenv("CONTROL_CHARS", tt.mode)
+			var edits AIEditActions
+			err := json.Unmarshal([]byte(cleanAIResponse(reply)), &edits)
+			if tt.content == "" {
+				if err == nil {
+					t.Fatal("reply with raw control characters parsed, want the invalid character error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reply didn't parse after cleanup: %v", err)
+			}
+			if got := edits.Actions[0].Content; got != tt.content {
+				t.Errorf("content = %q, want %q", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestUnquoteResponse(t *testing.T) {
+	object := `{"actions": [{"type": "delete", "path": "src/Old.tsx"}]}`
+	quoted, _ := json.Marshal(object)
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+		ok    bool
+	}{
+		{"object quoted once", string(quoted), object, true},
+		{"quoted object in a code fence", "```json\n" + string(quoted) + "\n```", object, true},
+		{"quoted object after thinking", "<think>hmm</think>\n" + string(quoted), object, true},
+		{"plain object", object, "", false},
+		{"quoted prose", `"I can't do that"`, "", false},
+		{"not a valid string", `"{\x"`, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := unquoteResponse(tt.reply)
+			if ok != tt.ok || (ok && got != tt.want) {
+				t.Errorf("unquoteResponse(%q) = %q, %v, want %q, %v", tt.reply, got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestDecodeEditActionsDoubleEncoded(t *testing.T) {
+	actions := `[{"type": "create", "path": "src/Foo.tsx", "content": "export const x = \"{}\";\n"}]`
+	encodedActions, _ := json.Marshal(actions)
+	encodedObject, _ := json.Marshal(`{"actions": ` + actions + `}`)
+	// The whole object quoted, with "actions" itself a string inside it
+	quotedTwice, _ := json.Marshal(`{"actions": ` + string(encodedActions) + `}`)
+
+	tests := []struct {
+		name  string
+		reply string
+	}{
+		{"plain", `{"actions": ` + actions + `}`},
+		{"actions as a string", `{"actions": ` + string(encodedActions) + `}`},
+		{"actions as a string holding the object", `{"actions": ` + string(encodedObject) + `}`},
+		{"quoted twice", string(quotedTwice)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text := tt.reply
+			if inner, ok := unquoteResponse(text); ok {
+				text = inner
+			}
+			edits, err := decodeEditActions(cleanAIResponse(text))
+			if err != nil {
+				t.Fatalf("decodeEditActions: %v", err)
+			}
+			if len(edits.Actions) != 1 {
+				t.Fatalf("got %d actions, want 1", len(edits.Actions))
+			}
+			act := edits.Actions[0]
+			if act.Type != "create" || act.Path != "src/Foo.tsx" || act.Content != "export const x = \"{}\";\n" {
+				t.Errorf("got %+v, want the create of src/Foo.tsx", act)
+			}
+		})
+	}
+}
+
+func TestDecodeEditActionsLeavesStringsAlone(t *testing.T) {
+	// Content that is itself JSON text must come out exactly as sent
+	content := `{"actions": "[1, 2]", "nested": "\"quoted\""}`
+	encoded, _ := json.Marshal(content)
+	reply := `{"actions": [{"type": "create", "path": "src/data.json", "content": ` + string(encoded) + `}]}`
+
+	if _, ok := unquoteResponse(reply); ok {
+		t.Fatal("unquoteResponse decoded a reply that isn't a JSON string")
+	}
+	edits, err := decodeEditActions(reply)
+	if err != nil {
+		t.Fatalf("decodeEditActions: %v", err)
+	}
+	if len(edits.Actions) != 1 || edits.Actions[0].Content != content {
+		t.Errorf("got %+v, want the content unchanged", edits.Actions)
+	}
+
+	// A string that doesn't hold JSON is an error, not an empty batch
+	if _, err := decodeEditActions(`{"actions": "create src/Foo.tsx"}`); err == nil {
+		t.Error("decodeEditActions accepted actions given as prose")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+)
+
+// Values of JSON_WRITE_STYLE
+const (
+	jsonStyleAsIs    = "as-is"
+	jsonStylePretty  = "pretty"
+	jsonStyleCompact = "compact"
+)
+
+// How a .json file is formatted before it is written, from
+// JSON_WRITE_STYLE: "as-is" (the default), "pretty" (two-space indent) or
+// "compact".
+func jsonWriteStyle() string {
+	style := strings.ToLower(getenvTrimmed("JSON_WRITE_STYLE"))
+	switch style {
+	case jsonStyleAsIs, jsonStylePretty, jsonStyleCompact:
+		return style
+	case "":
+	default:
+		log.Printf("Ignoring unknown JSON_WRITE_STYLE %q (want as-is, pretty or compact)", style)
+	}
+	return jsonStyleAsIs
+}
+
+// Reformats the content of a .json action in the configured style. Key
+// order is kept. Content that isn't plain JSON, such as a tsconfig with
+// comments, is written as-is.
+func formatJSONContent(path, content string) string {
+	if !strings.HasSuffix(strings.ToLower(path), ".json") {
+		return content
+	}
+	style := jsonWriteStyle()
+	if style == jsonStyleAsIs || !json.Valid([]byte(content)) {
+		return content
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(content)); err != nil {
+		return content
+	}
+	if style == jsonStyleCompact {
+		compact.WriteByte('\n')
+		return compact.String()
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return content
+	}
+	indented.WriteByte('\n')
+	return indented.String()
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+)
+
+// A file version the client already has, as sent in known_files
+type KnownFile struct {
+	Path string `json:"path"` // "src/..." path, normalized like action paths
+	Hash string `json:"hash"` // content hash, as in base_hashes
+}
+
+// Leaves out the content of files whose hash matches the version the client
+// says it has, marking them Known, so a response doesn't send back what the
+// client already holds. Only responses are shortened this way: the model
+// can't see earlier replies, so its prompt always has the full content.
+// Returns how many were left out.
+func omitKnownContent(files []FileJSON, known []KnownFile) int {
+	if len(known) == 0 {
+		return 0
+	}
+	hashes := make(map[string]string, len(known))
+	for _, file := range known {
+		if strings.TrimSpace(file.Path) != "" {
+			hashes[normalizePath(strings.TrimSpace(file.Path))] = strings.TrimSpace(file.Hash)
+		}
+	}
+
+	omitted := 0
+	for i, file := range files {
+		if file.Hash == "" {
+			continue
+		}
+		if hash, ok := hashes[file.Path]; ok && hash == file.Hash {
+			files[i].Content = ""
+			files[i].Known = true
+			omitted++
+		}
+	}
+	return omitted
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Values of EditRequest.Language and PROJECT_LANGUAGE
+const (
+	languageTS = "ts"
+	languageJS = "js"
+)
+
+// Longest UI library hint passed on to the prompt
+const maxUILibraryLength = 100
+
+// The language new code must be written in: the request's language, else
+// PROJECT_LANGUAGE. "" when neither is set, which keeps the TypeScript
+// prompt without enforcing file extensions.
+func projectLanguage(req EditRequest) string {
+	value := req.Language
+	if value == "" {
+		value = getenvTrimmed("PROJECT_LANGUAGE")
+	}
+	switch strings.ToLower(value) {
+	case "":
+		return ""
+	case languageTS, "typescript", "tsx":
+		return languageTS
+	case languageJS, "javascript", "jsx":
+		return languageJS
+	}
+	log.Printf("Ignoring unknown project language %q (want ts or js)", value)
+	return ""
+}
+
+// The UI library the model should build with, from the request or
+// UI_LIBRARY, as a single sanitized line
+func uiLibrary(req EditRequest) string {
+	value := req.UILibrary
+	if value == "" {
+		value = getenvTrimmed("UI_LIBRARY")
+	}
+	value = strings.Join(strings.Fields(sanitizeRules(value)), " ")
+	if len(value) > maxUILibraryLength {
+		value = strings.ToValidUTF8(value[:maxUILibraryLength], "")
+	}
+	return value
+}
+
+// Display name of a language for the prompt
+func languageName(language string) string {
+	if language == languageJS {
+		return "JavaScript"
+	}
+	return "TypeScript"
+}
+
+// Prompt rules for the chosen language and UI library, one "- " line each
+func languageRules(language, library string) string {
+	var rules []string
+	switch language {
+	case languageJS:
+		rules = append(rules,
+			"- This project uses plain JavaScript, NOT TypeScript. Write components as .jsx files (e.g. \"src/components/Counter.jsx\") and other modules as .js files.",
+			"- NEVER create .ts or .tsx files and do not use type annotations, interfaces or generics. Declare component props with PropTypes instead.",
+			"- Where the path rules above show .tsx, use .jsx instead (SidePanel.tsx keeps its name and must still not be modified).")
+	case languageTS:
+		rules = append(rules, "- Write all new code in TypeScript (.tsx for components, .ts for other modules) with typed props; never create .js or .jsx files.")
+	}
+	if library != "" {
+		rules = append(rules, fmt.Sprintf("- Build the UI with %s (already installed): use its components and styling conventions rather than hand-rolled equivalents, and don't add other UI libraries.", library))
+	}
+	if len(rules) == 0 {
+		return ""
+	}
+	return strings.Join(rules, "\n") + "\n"
+}
+
+// Rejects "create" actions for files in the other language, e.g. a .tsx
+// file in a JavaScript project. Returns "" when allowed.
+func languageRejection(language, actionType, normalizedPath string) string {
+	if actionType != "create" {
+		return ""
+	}
+	switch {
+	case language == languageJS && matchesFileType(normalizedPath, []string{".ts", ".tsx"}):
+		return "TypeScript file in a JavaScript project"
+	case language == languageTS && matchesFileType(normalizedPath, []string{".js", ".jsx"}):
+		return "JavaScript file in a TypeScript project"
+	}
+	return ""
+}
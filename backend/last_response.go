@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How many sessions keep their last model response in memory
+const maxStoredResponses = 100
+
+// The most recent model output seen for a session
+type LastResponse struct {
+	Provider   string    `json:"provider"`
+	Model      string    `json:"model"`
+	Raw        string    `json:"raw"`
+	Cleaned    string    `json:"cleaned"`
+	Parsed     bool      `json:"parsed"`
+	ParseError string    `json:"parse_error,omitempty"`
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+type lastResponseStore struct {
+	mu        sync.Mutex
+	responses map[string]*LastResponse
+}
+
+var lastResponses = &lastResponseStore{responses: map[string]*LastResponse{}}
+
+func (s *lastResponseStore) record(session string, resp *LastResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.responses[session]; !ok && len(s.responses) >= maxStoredResponses {
+		// Forget the oldest session to keep memory bounded
+		var oldest string
+		for key, stored := range s.responses {
+			if oldest == "" || stored.ReceivedAt.Before(s.responses[oldest].ReceivedAt) {
+				oldest = key
+			}
+		}
+		delete(s.responses, oldest)
+	}
+	s.responses[session] = resp
+}
+
+func (s *lastResponseStore) get(session string) *LastResponse {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.responses[session]
+}
+
+// Identifies the caller's session: the X-Session-ID header or ?session=
+// query parameter, falling back to the client IP
+func sessionKey(r *http.Request) string {
+	if id := r.Header.Get("X-Session-ID"); id != "" {
+		return id
+	}
+	if id := r.URL.Query().Get("session"); id != "" {
+		return id
+	}
+	return clientIP(r)
+}
+
+// Returns the last raw and cleaned model output for the caller's session
+func handleLastResponse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+
+	last := lastResponses.get(sessionKey(r))
+	if last == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No model response recorded for this session yet", nil)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(last)
+}
@@ -2,173 +2,1029 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
-	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const projectRoot = "../frontend/src" // Path to your React project folder
+// Path to your React project's src folder; PROJECT_ROOT overrides it at startup
+var projectRoot = "../frontend/src"
+
+// File types included in the prompt context unless CONTEXT_EXTENSIONS is set
+var defaultContextExtensions = []string{".tsx", ".ts", ".jsx", ".js", ".css", ".html"}
 
 // Request from frontend
 type EditRequest struct {
 	Instructions string `json:"instructions"`
-	Provider     string `json:"provider"` // "openrouter" or "ollama"
-	Model        string `json:"model"`
+	Provider     string `json:"provider"` // "openrouter", "ollama", "azure", "deepseek", "grok", or "mock" for tests
+	Model        string `json:"model"`    // full model ID or an alias from MODEL_ALIASES
+	// Optional values for {{key}} placeholders in Instructions
+	Variables map[string]string `json:"variables,omitempty"`
+	// Extra prompt rules for this request, e.g. "Always use Tailwind classes".
+	// Added after SYSTEM_PROMPT_FILE; the built-in rules can't be overridden.
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	// Never delete files in this request, regardless of what the model returns
+	NoDelete bool `json:"no_delete,omitempty"`
+	// Session used to look up the last model response; defaults to the
+	// X-Session-ID header or the client IP
+	SessionID string `json:"session_id,omitempty"`
+	// Action types the model may use, e.g. ["create", "update"]; empty allows all
+	AllowedActions []string `json:"allowed_actions,omitempty"`
+	// Content hashes of the files the actions were based on, as returned by
+	// /api/preview. Used by /api/apply to detect files changed since.
+	BaseHashes map[string]string `json:"base_hashes,omitempty"`
+	// Providers to try in order when the primary one fails; defaults to
+	// FALLBACK_PROVIDERS
+	Fallbacks []ProviderChoice `json:"fallbacks,omitempty"`
+	// Plan from /api/plan that the user approved; the edits must follow it
+	Plan []PlanStep `json:"plan,omitempty"`
+	// Overwrite existing files with "create" actions even when
+	// CREATE_EXISTING_MODE=confirm; set when re-sending confirmed actions
+	ConfirmOverwrite bool `json:"confirm_overwrite,omitempty"`
+	// Instructions to run one after another instead of Instructions, each
+	// against the files written by the previous ones
+	Batch []string `json:"batch,omitempty"`
+	// Keep running the remaining batch steps after one fails
+	ContinueOnError bool `json:"continue_on_error,omitempty"`
+	// Client-chosen ID for following the edit via /api/progress; the
+	// X-Request-ID header works too
+	RequestID string `json:"request_id,omitempty"`
+	// Return the final on-disk content of every created or updated file, so
+	// the client sees any normalization applied while writing
+	IncludeContent bool `json:"include_content,omitempty"`
+	// "edit" (default) or "explain" to get a plain-language explanation of
+	// the code instead of edits; nothing is written in explain mode
+	Mode string `json:"mode,omitempty"`
+	// "ts" or "js"; defaults to PROJECT_LANGUAGE
+	Language string `json:"language,omitempty"`
+	// UI library the model should build with, e.g. "Material UI"; defaults to UI_LIBRARY
+	UILibrary string `json:"ui_library,omitempty"`
+	// Git commit the actions were based on, as returned by /api/preview.
+	// /api/apply rejects the batch when files it touches changed since.
+	BaseRevision string `json:"base_revision,omitempty"`
+	// Files the change is about, e.g. ["src/components/Cart.tsx"]. The
+	// context is narrowed to them and their imports, and the model is told
+	// to focus on them; it may still create new files.
+	TargetFiles []string `json:"target_files,omitempty"`
+	// Number of candidate edits to generate. Above 1 nothing is applied; the
+	// candidates come back with diffs and one is applied with /api/apply.
+	N int `json:"n,omitempty"`
+	// Parse the reply exactly as the model sent it, without the cleanup
+	// heuristics, for models with structured output that return clean JSON.
+	// Parse errors report the line and column.
+	StrictJSON bool `json:"strict_json,omitempty"`
+	// Files the client already has, e.g. from an earlier include_content.
+	// include_content leaves out the content of files still at the listed
+	// hash. The model always gets the full context.
+	KnownFiles []KnownFile `json:"known_files,omitempty"`
 }
 
 // OpenRouter API response
 type OpenRouterResponse struct {
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage,omitempty"`
 	Choices []struct {
 		Message struct {
-			Content string `json:"content"`
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Function struct {
+					Name      string `json:"name"`
+					Arguments string `json:"arguments"`
+				} `json:"function"`
+			} `json:"tool_calls,omitempty"`
 		} `json:"message"`
 	} `json:"choices"`
 }
 
+// Returns the text of choice i. Models nudged towards function calling
+// sometimes leave content empty and put the actions JSON in a tool call's
+// arguments instead, so fall back to that.
+func (r *OpenRouterResponse) choiceText(i int) string {
+	message := r.Choices[i].Message
+	if strings.TrimSpace(message.Content) != "" {
+		return message.Content
+	}
+	for _, call := range message.ToolCalls {
+		if strings.TrimSpace(call.Function.Arguments) != "" {
+			return call.Function.Arguments
+		}
+	}
+	return message.Content
+}
+
 // Ollama API response
 type OllamaResponse struct {
 	Model     string `json:"model"`
 	CreatedAt string `json:"created_at"`
 	Response  string `json:"response"`
 	Done      bool   `json:"done"`
+	// Token counts, only set on the final response
+	PromptEvalCount int `json:"prompt_eval_count,omitempty"`
+	EvalCount       int `json:"eval_count,omitempty"`
+}
+
+// Token usage reported with the final Ollama response
+func (r *OllamaResponse) usage() *TokenUsage {
+	return &TokenUsage{PromptTokens: r.PromptEvalCount, CompletionTokens: r.EvalCount}
+}
+
+// What the model produced for an edit request
+type Generation struct {
+	Edits        AIEditActions
+	RawResponse  string
+	Usage        *TokenUsage
+	BaseHashes   map[string]string // file hashes the model's context was built from
+	BaseRevision string            // git HEAD when the context was gathered, "" outside a git repository
+	Provider     string            // provider that produced the edits, which may be a fallback
+	Model        string
+}
+
+// A single file change suggested by the AI
+type EditAction struct {
+	Type    string `json:"type"`              // "create", "update", "delete", "replace-range"
+	Path    string `json:"path"`              // relative path in project
+	Content string `json:"content,omitempty"` // new file content for create/update, new lines for replace-range
+	// 1-based, inclusive line range replaced by a replace-range action
+	StartLine int `json:"start_line,omitempty"`
+	EndLine   int `json:"end_line,omitempty"`
 }
 
 // The AI's suggested file changes
 type AIEditActions struct {
-	Actions []struct {
-		Type    string `json:"type"`              // "create", "update", "delete"
-		Path    string `json:"path"`              // relative path in project
-		Content string `json:"content,omitempty"` // new file content for create/update
-	} `json:"actions"`
+	Actions []EditAction `json:"actions"`
+	// Commentary some models add although the prompt asks for actions
+	// only; see modelMessage
+	Message looseText `json:"message,omitempty"`
+	Summary looseText `json:"summary,omitempty"`
+}
+
+// An action that applyEdits refused to perform
+type SkippedAction struct {
+	Type   string `json:"type"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// An action whose type was changed to match the state of the project
+type ActionCorrection struct {
+	Path string `json:"path"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// A problem noticed with a file after it was written
+type FileIssue struct {
+	Path  string `json:"path"`
+	Issue string `json:"issue"`
+}
+
+// How long one applied action took on disk and how much it wrote
+type ActionTiming struct {
+	Type       string  `json:"type"`
+	Path       string  `json:"path"`
+	Bytes      int     `json:"bytes"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Per-request restrictions on what applyEdits may do
+type ApplyOptions struct {
+	NoDelete         bool
+	AllowedActions   []string          // empty allows every action type
+	BaseHashes       map[string]string // hashes the edits were based on, to detect drift
+	BaseRevision     string            // git commit the edits were based on; see checkBaseRevision
+	ConfirmOverwrite bool              // "create" may replace existing files despite CREATE_EXISTING_MODE
+	Language         string            // "ts" or "js" rejects creating files in the other language
+	Root             string            // directory standing in for projectRoot, e.g. a sandbox copy
+}
+
+// Where a normalized path is written under these options
+func (o ApplyOptions) filePath(normalizedPath string) string {
+	if o.Root == "" {
+		return projectFilePath(normalizedPath)
+	}
+	return filepath.Join(o.Root, strings.TrimPrefix(normalizedPath, "src/"))
+}
+
+// The directory the edits are written to: Root, or projectRoot
+func (o ApplyOptions) rootDir() string {
+	if o.Root == "" {
+		return projectRoot
+	}
+	return o.Root
+}
+
+// Combines the request's options with the server-wide settings
+func applyOptionsFor(req EditRequest) ApplyOptions {
+	return ApplyOptions{
+		NoDelete:         req.NoDelete || envBool("NO_DELETE", false),
+		AllowedActions:   req.AllowedActions,
+		BaseHashes:       req.BaseHashes,
+		BaseRevision:     req.BaseRevision,
+		ConfirmOverwrite: req.ConfirmOverwrite,
+		Language:         projectLanguage(req),
+	}
+}
+
+// Reports whether the options permit the given action type
+func (o ApplyOptions) allows(actionType string) bool {
+	if len(o.AllowedActions) == 0 {
+		return true
+	}
+	for _, allowed := range o.AllowedActions {
+		if strings.EqualFold(allowed, actionType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Outcome of applying a batch of edit actions
+type ApplyResult struct {
+	Applied         int                `json:"applied"`
+	Changed         []string           `json:"changed,omitempty"`
+	Skipped         []SkippedAction    `json:"skipped,omitempty"`
+	Corrections     []ActionCorrection `json:"corrections,omitempty"`
+	WriteMismatches []FileIssue        `json:"write_mismatches,omitempty"`
+	HeadersRestored []string           `json:"headers_restored,omitempty"`
+	Conflicts       []FileIssue        `json:"conflicts,omitempty"`
+	// Files the model returned with exactly their current content; not rewritten
+	Unchanged []string `json:"unchanged,omitempty"`
+	// Action paths changed by PATH_REWRITES
+	PathRewrites []PathRewrite `json:"path_rewrites,omitempty"`
+	// Files the batch created more than once with different content
+	DuplicateCreates []string `json:"duplicate_creates,omitempty"`
+	// Files whose elision markers were replaced with the original code
+	ElisionsMerged []string `json:"elisions_merged,omitempty"`
+	// Set when ENTRY_EXPORT_CHECK finds the entry file lost its default export
+	EntryExport *EntryExportIssue `json:"entry_export,omitempty"`
+	// Disk time and bytes per applied action, and their totals
+	Timings      []ActionTiming `json:"timings,omitempty"`
+	BytesWritten int            `json:"bytes_written"`
+	ApplyMs      float64        `json:"apply_ms"`
+	// "create" actions held back until the user confirms the overwrite
+	PendingOverwrites []PendingOverwrite `json:"pending_overwrites,omitempty"`
+}
+
+// A "create" action for an existing file, with the diff it would cause
+type PendingOverwrite struct {
+	Action EditAction `json:"action"`
+	Diff   string     `json:"diff"`
 }
 
 type FileJSON struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Hash     string `json:"hash,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"` // reference only, the model must not edit it
+	Kind     string `json:"kind,omitempty"`      // see fileKind
+	// Content left out of a response because it matches a version the
+	// client listed in known_files
+	Known bool `json:"known,omitempty"`
 }
 
 func main() {
-	// Enable CORS
-	http.HandleFunc("/api/edit", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+	force := flag.Bool("force", false, "start even if the project root doesn't look like a React src folder")
+	flag.Parse()
+	loadEnvFiles()
+	if root := getenvTrimmed("PROJECT_ROOT"); root != "" {
+		projectRoot = filepath.Clean(root)
+	}
 
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
+	if err := checkProjectRoot(); err != nil {
+		if !*force {
+			log.Fatalf("Refusing to start: %v (use --force to start anyway)", err)
 		}
+		log.Printf("Warning: %v (continuing because of --force)", err)
+	}
 
-		handleEdit(w, r)
-	})
+	// Build the context index up front so the first edit is fast too
+	projectContextIndex()
+	pullOllamaModelOnStart()
+	logProviderAvailability()
+
+	shutdownTracing, err := initTracing()
+	if err != nil {
+		log.Printf("Tracing disabled: %v", err)
+	}
+
+	http.HandleFunc("/api/edit", withCORS(withRateLimit(handleEdit)))
 
 	// Add models endpoint
-	http.HandleFunc("/api/models", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.HandleFunc("/api/models", withCORS(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
-		models := map[string][]string{
-			"openrouter": {
-				"qwen/qwen-2.5-7b-instruct:free",
-				"qwen/qwen3-30b-a3b:free",
-				"meta-llama/llama-3.1-8b-instruct:free",
-				"anthropic/claude-3.5-sonnet",
-				"openai/gpt-4o",
-				"openai/gpt-4o-mini",
-				"google/gemini-pro-1.5",
-				"qwen/qwen-2.5-72b-instruct",
-			},
-			"ollama": {
-				"llama3.2",
-				"qwen2.5",
-				"codellama",
-				"deepseek-coder",
-				"starcoder2",
-			},
+		json.NewEncoder(w).Encode(modelsResponse(modelLists.get()))
+	}))
+
+	// Force-refresh the cached model lists (e.g. after pulling a new Ollama model)
+	http.HandleFunc("/api/models/refresh", withCORS(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeMethodNotAllowed(w, "POST")
+			return
 		}
 
-		json.NewEncoder(w).Encode(models)
-	})
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(modelsResponse(modelLists.refresh()))
+	}))
+
+	http.HandleFunc("/api/preview", withCORS(withRateLimit(handlePreview)))
+	http.HandleFunc("/api/plan", withCORS(withRateLimit(handlePlan)))
+	http.HandleFunc("/api/execute-plan", withCORS(withRateLimit(handleExecutePlan)))
+	http.HandleFunc("/api/apply", withCORS(handleApply))
+	http.HandleFunc("/api/sandbox", withCORS(withRateLimit(handleSandbox)))
+	http.HandleFunc("/api/sandbox/promote", withCORS(handleSandboxPromote))
+	http.HandleFunc("/api/sandbox/discard", withCORS(handleSandboxDiscard))
+	http.HandleFunc("/api/last-response", withCORS(handleLastResponse))
+	http.HandleFunc("/api/progress", withCORS(handleProgress))
+	http.HandleFunc("/api/jobs/", withCORS(handleJob))
+	http.HandleFunc("/api/warmup", withCORS(handleWarmup))
+	http.HandleFunc("/api/ready", withCORS(handleReady))
+	http.HandleFunc("/api/prompt/test", withCORS(withRateLimit(handlePromptTest)))
+	http.HandleFunc("/api/files", withCORS(handleFiles))
+	http.HandleFunc("/api/export", withCORS(handleExport))
+	// Destructive endpoints get no CORS headers, so other sites can't call them
+	http.HandleFunc("/api/import", withSameOrigin(handleImport))
+	http.HandleFunc("/api/reset", withSameOrigin(handleReset))
+
+	if envBool("SERVE_UI", false) {
+		http.Handle("/", uiHandler())
+		fmt.Println("Review UI enabled at http://localhost:8080/")
+	}
+
+	// Explicit timeouts keep slow or idle clients from holding connections
+	// open forever. WriteTimeout is long because edits wait on the model.
+	server := &http.Server{
+		Addr:              ":8080",
+		ReadHeaderTimeout: envDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       envDuration("SERVER_READ_TIMEOUT", 60*time.Second),
+		WriteTimeout:      envDuration("SERVER_WRITE_TIMEOUT", 15*time.Minute),
+		IdleTimeout:       envDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute),
+		MaxHeaderBytes:    envInt("SERVER_MAX_HEADER_BYTES", 1<<16),
+	}
 
 	fmt.Println("Backend running at http://localhost:8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.ListenAndServe() }()
+
+	// On Ctrl+C or SIGTERM, let in-flight requests finish for a moment and
+	// flush the buffered trace spans before exiting
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serveErr:
+		shutdownTracing(context.Background())
+		log.Fatal(err)
+	case sig := <-stop:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down the server cleanly: %v", err)
+	}
+	if err := shutdownTracing(ctx); err != nil {
+		log.Printf("Failed to flush trace spans: %v", err)
+	}
+}
+
+// Enable CORS and answer preflight requests
+func withCORS(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// For endpoints that must not be reachable from other websites: sends no
+// CORS headers, so browsers don't let other origins read the response or
+// send non-simple requests, and rejects browser requests whose Origin
+// isn't this server. Tools like curl send no Origin and are let through.
+func withSameOrigin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, errCodeForbidden, "Cross-origin requests are not allowed on this endpoint", nil)
+				return
+			}
+		}
+		handler(w, r)
+	}
 }
 
 // Handle user edit requests
 func handleEdit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Only POST allowed", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, "POST")
 		return
 	}
 
 	var req EditRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
 		return
 	}
+	if req.SessionID == "" {
+		req.SessionID = sessionKey(r)
+	}
+
+	// Long edits can run in the background and be polled at /api/jobs/<id>
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		enqueueEdit(w, r, req)
+		return
+	}
+	serveEdit(w, r, req)
+}
 
-	contextJSON, err := gatherContextJSON()
+// Generates and applies the edits for a decoded request and writes the response
+func serveEdit(w http.ResponseWriter, r *http.Request, req EditRequest) {
+	switch strings.ToLower(req.Mode) {
+	case "", modeEdit:
+	case modeExplain:
+		serveExplain(w, r, req)
+		return
+	default:
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, fmt.Sprintf("Unknown mode %q; use %q or %q", req.Mode, modeEdit, modeExplain), nil)
+		return
+	}
+
+	if len(req.Batch) > 0 {
+		serveBatch(w, r, req)
+		return
+	}
+	if req.N > 1 {
+		serveCandidates(w, r, req)
+		return
+	}
+
+	ctx, tracker := startProgress(r, req)
+	ctx, span := tracer.Start(ctx, "edit", trace.WithAttributes(requestAttributes(req)...))
+	defer span.End()
+
+	ctx, branch, err := startEditBranch(ctx)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		tracker.finish(err)
+		recordSpanError(span, err)
+		writeAPIError(w, err)
 		return
 	}
+	defer branch.finish()
 
-	prompt := buildPrompt(req.Instructions, contextJSON)
+	// Experimental: apply each action as soon as it has streamed in
+	if envBool("STREAM_APPLY", false) && req.Provider == "ollama" {
+		outcome, err := streamEdits(ctx, req)
+		tracker.finish(err)
+		if err != nil {
+			recordSpanError(span, err)
+			writeAPIError(w, err)
+			return
+		}
+		req.Model = resolveModelAlias(req.Model)
+		writeAuditManifest(r.URL.Path, req, outcome.Actions, outcome.Result)
+		response := buildApplyResponse(outcome.Result, outcome.Options)
+		response["progress"] = outcome.Progress
+		addModelMessage(response, outcome.Edits)
+		addContextScope(response, outcome.BaseHashes, outcome.Result.Changed)
+		if req.IncludeContent {
+			response["files"] = appliedFiles(outcome.Result.Changed, req.KnownFiles, outcome.Options)
+		}
+		if outcome.Usage != nil {
+			response["usage"] = outcome.Usage
+		}
+		if branch != nil {
+			branch.commit(ctx, outcome.Result.Changed, req.Instructions)
+			response["branch"] = branch
+		}
 
-	var aiResponse string
-	var parseErr error
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
 
-	switch req.Provider {
-	case "openrouter":
-		aiResponse, parseErr = callOpenRouter(prompt, req.Model)
-	case "ollama":
-		aiResponse, parseErr = callOllama(prompt, req.Model)
-	default:
-		http.Error(w, "Invalid provider. Use 'openrouter' or 'ollama'", http.StatusBadRequest)
+	gen, err := generateEdits(ctx, req)
+	if err != nil {
+		tracker.finish(err)
+		recordSpanError(span, err)
+		writeAPIError(w, err)
 		return
 	}
 
-	if parseErr != nil {
-		http.Error(w, parseErr.Error(), http.StatusInternalServerError)
+	opts := applyOptionsFor(req)
+	opts.BaseHashes = gen.BaseHashes
+	opts.BaseRevision = gen.BaseRevision
+	branch.applyTo(&opts)
+	result, err := applyEdits(ctx, gen.Edits, opts)
+	tracker.finish(err)
+	if err != nil {
+		recordSpanError(span, err)
+		writeAPIError(w, err)
 		return
 	}
+	req.Provider, req.Model = gen.Provider, gen.Model
+	writeAuditManifest(r.URL.Path, req, gen.Edits.Actions, result)
+
+	response := buildApplyResponse(result, opts)
+	response["provider"] = gen.Provider
+	response["model"] = gen.Model
+	addModelMessage(response, gen.Edits)
+	addContextScope(response, gen.BaseHashes, result.Changed)
+	if req.IncludeContent {
+		response["files"] = appliedFiles(result.Changed, req.KnownFiles, opts)
+	}
+	if gen.Usage != nil {
+		response["usage"] = gen.Usage
+	}
+	if branch != nil {
+		branch.commit(ctx, result.Changed, req.Instructions)
+		response["branch"] = branch
+	}
 
-	// Clean up the AI response before parsing
-	cleanedResponse := cleanAIResponse(aiResponse)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Gathers the project context, asks the provider for edits and parses the
+// reply without applying anything. Failures are returned as *APIError.
+func generateEdits(ctx context.Context, req EditRequest) (*Generation, error) {
+	req.Model = resolveModelAlias(req.Model)
+
+	prompt, err := buildEditPrompt(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var gen *Generation
+	_, _, err = withFallbacks(req, prompt, func(req EditRequest, prompt *EditPrompt) error {
+		var err error
+		gen, err = generateFromPrompt(ctx, req, prompt)
+		return err
+	})
+	return gen, err
+}
+
+// Sends an already built prompt to the request's provider and parses the reply
+func generateFromPrompt(ctx context.Context, req EditRequest, prompt *EditPrompt) (*Generation, error) {
+	aiResponse, usage, err := callProvider(ctx, req, prompt.Text)
+	if err != nil {
+		return nil, err
+	}
+	return parseGeneration(ctx, req, prompt, aiResponse, usage)
+}
+
+// Parses a raw model reply to prompt into edits
+func parseGeneration(ctx context.Context, req EditRequest, prompt *EditPrompt, aiResponse string, usage *TokenUsage) (*Generation, error) {
+	// Some models send the whole object as one JSON string
+	text := aiResponse
+	if inner, ok := unquoteResponse(aiResponse); ok && !req.StrictJSON {
+		log.Printf("Response is a JSON string, decoding the object inside it")
+		text = inner
+	}
+
+	if err := refusalError(text); err != nil {
+		lastResponses.record(req.SessionID, &LastResponse{
+			Provider:   req.Provider,
+			Model:      req.Model,
+			Raw:        aiResponse,
+			ParseError: err.Error(),
+			ReceivedAt: time.Now(),
+		})
+		return nil, err
+	}
+
+	// Clean up the AI response before parsing, unless the client vouches
+	// for it; the cleanup can mangle valid JSON
+	cleanedResponse := text
+	if !req.StrictJSON {
+		cleanedResponse = tracedCleanAIResponse(ctx, text)
+	}
+
+	last := &LastResponse{
+		Provider:   req.Provider,
+		Model:      req.Model,
+		Raw:        aiResponse,
+		Cleaned:    cleanedResponse,
+		Parsed:     true,
+		ReceivedAt: time.Now(),
+	}
+	defer lastResponses.record(req.SessionID, last)
 
 	var edits AIEditActions
-	if err := json.Unmarshal([]byte(cleanedResponse), &edits); err != nil {
+	var err error
+	if req.StrictJSON {
+		err = json.Unmarshal([]byte(cleanedResponse), &edits)
+	} else {
+		edits, err = decodeEditActions(cleanedResponse)
+	}
+	if err != nil {
+		last.Parsed = false
+		last.ParseError = err.Error()
 		log.Printf("Failed to parse AI response as JSON: %v", err)
 		log.Printf("Original response: %s", aiResponse)
 		log.Printf("Cleaned response: %s", cleanedResponse)
-		http.Error(w, fmt.Sprintf("Failed to parse AI response as JSON: %v\nOriginal Response: %s", err, aiResponse), http.StatusInternalServerError)
-		return
+		if truncated := truncationError(text); truncated != nil {
+			last.ParseError = truncated.Error()
+			return nil, truncated
+		}
+		if req.StrictJSON {
+			return nil, strictJSONError(aiResponse, err)
+		}
+		return nil, newAPIError(http.StatusBadGateway, errCodeParseFailed, fmt.Sprintf("Failed to parse AI response as JSON: %v", err), map[string]string{"original_response": aiResponse})
 	}
 
-	if err := applyEdits(edits); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	return &Generation{
+		Edits:        edits,
+		RawResponse:  aiResponse,
+		Usage:        usage,
+		BaseHashes:   prompt.BaseHashes,
+		BaseRevision: prompt.BaseRevision,
+		Provider:     req.Provider,
+		Model:        req.Model,
+	}, nil
+}
+
+// Sends a prompt to the request's provider and returns the raw reply with
+// its usage and estimated cost. Failures are returned as *APIError.
+func callProvider(ctx context.Context, req EditRequest, prompt string) (string, *TokenUsage, error) {
+	responses, usage, err := callProviderChoices(ctx, req, prompt, 1)
+	if err != nil {
+		return "", nil, err
+	}
+	return responses[0], usage, nil
+}
+
+// Like callProvider, but asks for n replies to the same prompt. OpenRouter,
+// Azure and xAI return them from one request ("n"); other providers are
+// called n times. The usage covers all replies. Chat completion providers
+// may return fewer than n.
+func callProviderChoices(ctx context.Context, req EditRequest, prompt string, n int) ([]string, *TokenUsage, error) {
+	var responses []string
+	var usage *TokenUsage
+	var callErr error
+
+	release, err := acquireProviderSlot(ctx, req.Provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	progressFrom(ctx).setPhase(phaseWaiting)
+
+	callCtx, callSpan := tracer.Start(ctx, "provider.call", trace.WithAttributes(requestAttributes(req)...))
+	callSpan.SetAttributes(attribute.Int("edit.prompt_bytes", len(prompt)))
+	switch req.Provider {
+	case "openrouter":
+		responses, usage, callErr = callOpenRouter(callCtx, prompt, req.Model, n)
+	case "ollama":
+		responses, usage, callErr = repeatProviderCall(n, func() (string, *TokenUsage, error) { return callOllama(callCtx, prompt, req.Model) })
+	case "azure":
+		responses, usage, callErr = callAzure(callCtx, prompt, req.Model, n)
+	case "deepseek":
+		responses, usage, callErr = repeatProviderCall(n, func() (string, *TokenUsage, error) { return callDeepSeek(callCtx, prompt, req.Model) })
+	case "grok":
+		responses, usage, callErr = callGrok(callCtx, prompt, req.Model, n)
+	case "mock":
+		if !envBool("ENABLE_MOCK_PROVIDER", false) {
+			release()
+			callSpan.End()
+			return nil, nil, newAPIError(http.StatusBadRequest, errCodeInvalidProvider, "The mock provider is disabled; set ENABLE_MOCK_PROVIDER=true to enable it", nil)
+		}
+		responses, usage, callErr = repeatProviderCall(n, func() (string, *TokenUsage, error) { return callMock(prompt, req.Model) })
+	default:
+		release()
+		callSpan.End()
+		return nil, nil, newAPIError(http.StatusBadRequest, errCodeInvalidProvider, "Invalid provider. Use 'openrouter', 'ollama', 'azure', 'deepseek', 'grok' or 'mock'", nil)
+	}
+	release()
+	responseBytes := 0
+	for _, response := range responses {
+		responseBytes += len(response)
+	}
+	callSpan.SetAttributes(attribute.Int("edit.response_bytes", responseBytes))
+	recordSpanError(callSpan, callErr)
+	callSpan.End()
+
+	if callErr != nil {
+		return nil, nil, newAPIError(http.StatusBadGateway, errCodeUpstream, callErr.Error(), map[string]string{"provider": req.Provider, "model": req.Model})
+	}
+
+	usage.estimateCost(req.Provider, req.Model)
+	return responses, usage, nil
+}
+
+// Makes n calls one after another for providers without "n" support,
+// adding up the token counts
+func repeatProviderCall(n int, call func() (string, *TokenUsage, error)) ([]string, *TokenUsage, error) {
+	if n < 1 {
+		n = 1
 	}
+	var responses []string
+	var total *TokenUsage
+	for i := 0; i < n; i++ {
+		response, usage, err := call()
+		if err != nil {
+			return nil, nil, err
+		}
+		responses = append(responses, response)
+		if usage != nil {
+			if total == nil {
+				total = &TokenUsage{}
+			}
+			total.PromptTokens += usage.PromptTokens
+			total.CompletionTokens += usage.CompletionTokens
+		}
+	}
+	return responses, total, nil
+}
 
+// Builds the JSON response for an applied edit batch and runs the post-edit hook
+func buildApplyResponse(result *ApplyResult, opts ApplyOptions) map[string]interface{} {
 	response := map[string]interface{}{
 		"status":  "success",
-		"applied": len(edits.Actions),
+		"applied": result.Applied,
+	}
+	if len(result.Skipped) > 0 {
+		response["skipped"] = result.Skipped
+	}
+	if len(result.Corrections) > 0 {
+		response["corrections"] = result.Corrections
+	}
+	if len(result.WriteMismatches) > 0 {
+		response["write_mismatches"] = result.WriteMismatches
 	}
+	if len(result.HeadersRestored) > 0 {
+		response["headers_restored"] = result.HeadersRestored
+	}
+	if len(result.PathRewrites) > 0 {
+		response["path_rewrites"] = result.PathRewrites
+	}
+	if len(result.DuplicateCreates) > 0 {
+		response["duplicate_creates"] = result.DuplicateCreates
+	}
+	if len(result.ElisionsMerged) > 0 {
+		response["elisions_merged"] = result.ElisionsMerged
+	}
+	if result.EntryExport != nil {
+		response["entry_export"] = result.EntryExport
+	}
+	if len(result.Conflicts) > 0 {
+		response["conflicts"] = result.Conflicts
+	}
+	if len(result.PendingOverwrites) > 0 {
+		response["pending_overwrites"] = result.PendingOverwrites
+	}
+	if len(result.Unchanged) > 0 {
+		response["unchanged"] = result.Unchanged
+	}
+	response["timings"] = map[string]interface{}{
+		"actions":       result.Timings,
+		"bytes_written": result.BytesWritten,
+		"apply_ms":      result.ApplyMs,
+	}
+	if len(result.Changed) > 0 && envBool("CHECK_IMPORTS", false) {
+		if issues := unresolvedImports(result.Changed, opts); len(issues) > 0 {
+			response["unresolved_imports"] = issues
+		}
+	}
+	if len(result.Changed) > 0 {
+		if hook := runPostEditHook(result.Changed, filepath.Dir(opts.rootDir())); hook != nil {
+			response["hook"] = hook
+		}
+	}
+	return response
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+// Reads back the changed files as they are on disk after writing, with their
+// hashes for a follow-up base_hashes. Deleted files are left out, and files
+// the client listed in known_files at the same hash come without content.
+func appliedFiles(changed []string, known []KnownFile, opts ApplyOptions) []FileJSON {
+	files := make([]FileJSON, 0, len(changed))
+	for _, path := range changed {
+		content, err := ioutil.ReadFile(opts.filePath(path))
+		if err != nil {
+			continue
+		}
+		files = append(files, FileJSON{Path: path, Content: string(content), Hash: contentHash(content)})
+	}
+	if omitted := omitKnownContent(files, known); omitted > 0 {
+		log.Printf("Returned %d files the client already has without their content", omitted)
+	}
+	return files
+}
+
+// Reports which writable files the model was given ("context_files") and
+// which of those it left alone ("unchanged_context_files"), so the UI can
+// show the scope of an edit
+func addContextScope(response map[string]interface{}, baseHashes map[string]string, changed []string) {
+	touched := make(map[string]bool, len(changed))
+	for _, path := range changed {
+		touched[path] = true
+	}
+
+	provided := make([]string, 0, len(baseHashes))
+	unchanged := []string{}
+	for path := range baseHashes {
+		provided = append(provided, path)
+		if !touched[path] {
+			unchanged = append(unchanged, path)
+		}
+	}
+	sort.Strings(provided)
+	sort.Strings(unchanged)
+
+	response["context_files"] = provided
+	response["unchanged_context_files"] = unchanged
+}
+
+// A built prompt and the state of the project it was built from
+type EditPrompt struct {
+	Text         string
+	BaseHashes   map[string]string // "src/..." path -> content hash when the context was gathered
+	BaseRevision string            // git HEAD when the context was gathered
+
+	// What Text was built from, to rebuild it for a fallback provider
+	instructions string
+	filesJSON    string
+	options      PromptOptions
+}
+
+// The same prompt rebuilt for another provider and model, which may have
+// their own PROMPT_TEMPLATES entry. The context is not gathered again.
+func (p *EditPrompt) forProvider(provider, model string) *EditPrompt {
+	rebuilt := *p
+	rebuilt.options.Provider, rebuilt.options.Model = provider, model
+	rebuilt.Text = buildPrompt(p.instructions, p.filesJSON, rebuilt.options)
+	return &rebuilt
+}
+
+// Gathers the project context and builds the full prompt for an edit request
+func buildEditPrompt(ctx context.Context, req EditRequest) (*EditPrompt, error) {
+	filesJSON, hashes, omitted, err := gatherPromptContext(ctx, req.Instructions, req.TargetFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	_, promptSpan := tracer.Start(ctx, "buildPrompt")
+	defer promptSpan.End()
+
+	instructions, err := expandInstructions(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.Plan) > 0 {
+		instructions += approvedPlanText(req.Plan)
+	}
+	instructions += targetFilesNote(req.TargetFiles)
+	opts := promptOptionsFor(req)
+	opts.History = gitHistory(ctx)
+	opts.OmittedFiles = omitted
+	text := buildPrompt(instructions, filesJSON, opts)
+	promptSpan.SetAttributes(attribute.Int("edit.prompt_bytes", len(text)))
+	return &EditPrompt{Text: text, BaseHashes: hashes, BaseRevision: currentRevision(ctx), instructions: instructions, filesJSON: filesJSON, options: opts}, nil
+}
+
+// Gathers the project files as the JSON array sent in prompts, plus the
+// content hash of every writable file keyed by its "src/..." path. With
+// target files, only those, their imports and the essential files are
+// sent; beyond MAX_CONTEXT_FILES, the files least relevant to the
+// instructions are left out. The paths of left out files are returned.
+func gatherPromptContext(ctx context.Context, instructions string, targets []string) (string, map[string]string, []string, error) {
+	progressFrom(ctx).setPhase(phaseGathering)
+	_, span := tracer.Start(ctx, "gatherContext")
+	defer span.End()
+
+	files, err := gatherContextFiles()
+	if err != nil {
+		recordSpanError(span, err)
+		return "", nil, nil, err
+	}
+	files = editBranchFrom(ctx).overlay(files)
+	files, outOfScope := scopeToTargets(files, targets)
+	if len(outOfScope) > 0 {
+		log.Printf("Left %d files outside the target files out of the context", len(outOfScope))
+	}
+	files, omitted := limitContextFiles(files, instructions)
+	if len(omitted) > 0 {
+		log.Printf("Left %d files out of the context (MAX_CONTEXT_FILES)", len(omitted))
+	}
+	omitted = append(outOfScope, omitted...)
+
+	jsonBytes, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		recordSpanError(span, err)
+		return "", nil, nil, err
+	}
+	span.SetAttributes(attribute.Int("edit.context_files", len(files)), attribute.Int("edit.context_bytes", len(jsonBytes)),
+		attribute.Int("edit.context_files_omitted", len(omitted)))
+
+	hashes := make(map[string]string, len(files))
+	for _, file := range files {
+		if !file.ReadOnly {
+			hashes[contextFileKey(file)] = file.Hash
+		}
+	}
+	return string(jsonBytes), hashes, omitted, nil
 }
 
 // Reads project files into JSON array
 func gatherContextJSON() (string, error) {
+	files, err := gatherContextFiles()
+	if err != nil {
+		return "", err
+	}
+
+	jsonBytes, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+// Short content hash used to detect files that changed after the context was gathered
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Reads the project files that make up the prompt context
+func gatherContextFiles() ([]FileJSON, error) {
+	files, err := gatherSourceFiles()
+	if err != nil {
+		return nil, err
+	}
+	scanned, err := gatherScanDirFiles()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, scanned...)
+
+	// Project-level files that help the model, even though they live outside src/
+	for _, name := range alwaysIncludeFiles() {
+		b, err := ioutil.ReadFile(filepath.Join(filepath.Dir(projectRoot), name))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("Failed to read always-included file %s: %v", name, err)
+			}
+			continue
+		}
+		files = append(files, FileJSON{
+			Path:     name,
+			Content:  string(b),
+			Hash:     contentHash(b),
+			ReadOnly: true,
+		})
+	}
+
+	for i := range files {
+		files[i].Kind = fileKind(files[i].Path)
+	}
+
+	// Keep the order stable so the same project always produces the same prompt
+	sort.Slice(files, func(i, j int) bool {
+		return filepath.ToSlash(files[i].Path) < filepath.ToSlash(files[j].Path)
+	})
+
+	return files, nil
+}
+
+// Reads the context-eligible files under projectRoot, from the in-memory
+// index when CONTEXT_INDEX is on
+func gatherSourceFiles() ([]FileJSON, error) {
+	if idx := projectContextIndex(); idx != nil {
+		return idx.contextFiles(contextExtensions())
+	}
+
 	files := []FileJSON{}
 
 	err := filepath.WalkDir(projectRoot, func(path string, d fs.DirEntry, err error) error {
@@ -179,9 +1035,7 @@ func gatherContextJSON() (string, error) {
 			return nil
 		}
 
-		if strings.HasSuffix(path, ".tsx") || strings.HasSuffix(path, ".ts") ||
-			strings.HasSuffix(path, ".jsx") || strings.HasSuffix(path, ".js") ||
-			strings.HasSuffix(path, ".css") || strings.HasSuffix(path, ".html") {
+		if matchesFileType(path, contextExtensions()) {
 
 			b, err := ioutil.ReadFile(path)
 			if err != nil {
@@ -191,20 +1045,66 @@ func gatherContextJSON() (string, error) {
 			files = append(files, FileJSON{
 				Path:    rel,
 				Content: string(b),
+				Hash:    contentHash(b),
 			})
 		}
 		return nil
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return files, nil
+}
 
-	jsonBytes, err := json.MarshalIndent(files, "", "  ")
-	if err != nil {
-		return "", err
+// Files next to src/ that are always sent as read-only reference, from
+// ALWAYS_INCLUDE (default package.json and tsconfig.json)
+func alwaysIncludeFiles() []string {
+	if list := envList("ALWAYS_INCLUDE"); len(list) > 0 {
+		return list
 	}
+	return []string{"package.json", "tsconfig.json"}
+}
+
+// Reports whether a normalized action path targets one of the always-included files
+func isAlwaysIncluded(normalizedPath string) bool {
+	for _, name := range alwaysIncludeFiles() {
+		if normalizedPath == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Extensions (".tsx") or file names ("package-lock.json") the model gets to
+// read, from CONTEXT_EXTENSIONS
+func contextExtensions() []string {
+	if list := envList("CONTEXT_EXTENSIONS"); len(list) > 0 {
+		return list
+	}
+	return defaultContextExtensions
+}
+
+// Extensions or file names the model may write, from WRITABLE_EXTENSIONS.
+// Nil means every file type is writable.
+func writableExtensions() []string {
+	return envList("WRITABLE_EXTENSIONS")
+}
 
-	return string(jsonBytes), nil
+// Reports whether path has one of the extensions in types or is named like
+// one of its entries. Entries starting with "." are extensions.
+func matchesFileType(path string, types []string) bool {
+	lower := strings.ToLower(path)
+	base := filepath.Base(lower)
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if strings.HasPrefix(t, ".") && strings.HasSuffix(lower, t) {
+			return true
+		}
+		if base == t {
+			return true
+		}
+	}
+	return false
 }
 
 // Extract file structure to show LLM the current project layout
@@ -216,6 +1116,14 @@ func extractFileStructure(filesJSON string) string {
 
 	structure := "Current files in the project:\n"
 	for _, file := range files {
+		if file.ReadOnly {
+			structure += fmt.Sprintf("- %s (reference only, DO NOT MODIFY)\n", file.Path)
+			continue
+		}
+		if _, _, ok := scanDirFor(filepath.ToSlash(file.Path)); ok {
+			structure += fmt.Sprintf("- %s (outside src/; use this exact path, without a src/ prefix)\n", file.Path)
+			continue
+		}
 		structure += fmt.Sprintf("- %s\n", file.Path)
 	}
 
@@ -230,13 +1138,33 @@ func extractFileStructure(filesJSON string) string {
 	return structure
 }
 
+// A Windows drive letter at the start of a path, e.g. "C:"
+var windowsDrivePattern = regexp.MustCompile(`^[A-Za-z]:`)
+
 // Normalize and validate file paths to prevent incorrect nesting
 func normalizePath(path string) string {
+	// Normalize Windows-style separators first so every rule below sees forward slashes
+	path = strings.ReplaceAll(path, "\\", "/")
+
+	// Drop a drive letter and everything before the project's src/ folder,
+	// e.g. "C:/work/frontend/src/App.tsx" -> "src/App.tsx"
+	if windowsDrivePattern.MatchString(path) {
+		path = path[2:]
+		if i := strings.Index(path, "/src/"); i >= 0 {
+			path = path[i+1:]
+		}
+	}
+
 	// Remove any leading slashes or dots
 	path = strings.TrimPrefix(path, "/")
 	path = strings.TrimPrefix(path, "./")
 	path = strings.TrimPrefix(path, "../")
 
+	// Files in EXTRA_SCAN_DIRS keep their "<name>/..." path
+	if _, _, ok := scanDirFor(path); ok {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+
 	// Fix common path mistakes
 	// Fix double src directories (src/src/... -> src/...)
 	for strings.HasPrefix(path, "src/src/") {
@@ -264,16 +1192,13 @@ func normalizePath(path string) string {
 		// Special handling for components
 		if strings.Contains(path, "Component") ||
 			strings.Contains(path, "component") ||
-			(strings.HasSuffix(path, ".tsx") && !strings.Contains(path, "App") && !strings.Contains(path, "main")) {
+			(isComponentFile(path) && !strings.Contains(path, "App") && !strings.Contains(path, "main")) {
 			path = "src/components/" + filepath.Base(path)
 		} else {
 			path = "src/" + path
 		}
 	}
 
-	// Normalize path separators
-	path = strings.ReplaceAll(path, "\\", "/")
-
 	// Remove any double slashes
 	for strings.Contains(path, "//") {
 		path = strings.ReplaceAll(path, "//", "/")
@@ -281,9 +1206,9 @@ func normalizePath(path string) string {
 
 	// Ensure components go in the components directory
 	if strings.HasPrefix(path, "src/") &&
-		strings.HasSuffix(path, ".tsx") &&
-		!strings.Contains(path, "App.tsx") &&
-		!strings.Contains(path, "main.tsx") &&
+		isComponentFile(path) &&
+		!strings.Contains(path, "App.") &&
+		!strings.Contains(path, "main.") &&
 		!strings.Contains(path, "SidePanel.tsx") &&
 		!strings.HasPrefix(path, "src/components/") {
 
@@ -294,12 +1219,77 @@ func normalizePath(path string) string {
 	return path
 }
 
+// Reports whether a path is a React component file: .tsx, or .jsx in
+// JavaScript projects
+func isComponentFile(path string) bool {
+	return strings.HasSuffix(path, ".tsx") || strings.HasSuffix(path, ".jsx")
+}
+
+var templateVariablePattern = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_.-]+)\s*\}\}`)
+
+// Replaces {{key}} placeholders in the instructions with values from vars.
+// This is plain text substitution; placeholders without a value are left as-is.
+func substituteVariables(instructions string, vars map[string]string) string {
+	if len(vars) == 0 {
+		return instructions
+	}
+	return templateVariablePattern.ReplaceAllStringFunc(instructions, func(match string) string {
+		key := templateVariablePattern.FindStringSubmatch(match)[1]
+		if value, ok := vars[key]; ok {
+			return value
+		}
+		return match
+	})
+}
+
+// Maps a normalized "src/..." path to its location on disk
+func projectFilePath(normalizedPath string) string {
+	if dir, rel, ok := scanDirFor(normalizedPath); ok {
+		return filepath.Join(dir.Dir, filepath.FromSlash(rel))
+	}
+	return filepath.Join(projectRoot, strings.TrimPrefix(normalizedPath, "src/"))
+}
+
+// Request-specific parts of the edit prompt
+type PromptOptions struct {
+	AllowedActions []string // restricts the action types the model is told it may use
+	ExtraRules     string   // user rules appended after the built-in ones
+	History        string   // recent commits and uncommitted changes, see gitHistory
+	Language       string   // "ts", "js" or "" (TypeScript prompt, nothing enforced)
+	UILibrary      string
+	OmittedFiles   []string // "src/..." paths left out by MAX_CONTEXT_FILES
+	// Provider and model the prompt is for, used to pick a PROMPT_TEMPLATES entry
+	Provider string
+	Model    string
+}
+
+func promptOptionsFor(req EditRequest) PromptOptions {
+	return PromptOptions{
+		AllowedActions: req.AllowedActions,
+		ExtraRules:     extraPromptRules(req),
+		Provider:       req.Provider,
+		Model:          req.Model,
+		Language:       projectLanguage(req),
+		UILibrary:      uiLibrary(req),
+	}
+}
+
 // Builds strict JSON edit prompt
-func buildPrompt(instructions string, filesJSON string) string {
+func buildPrompt(instructions string, filesJSON string, opts PromptOptions) string {
 	// Extract current file structure for the LLM
-	fileStructure := extractFileStructure(filesJSON)
+	fileStructure := extractFileStructure(filesJSON) + fileKindsNote(filesJSON) + omittedFilesNote(opts.OmittedFiles) + referenceDocsSection()
+
+	permissions := "- You are allowed to create, update, or delete files."
+	if len(opts.AllowedActions) > 0 {
+		permissions = fmt.Sprintf("- You may ONLY use these action types: %s. Any other action type will be rejected.",
+			strings.Join(opts.AllowedActions, ", "))
+	}
 
-	return fmt.Sprintf(`You are a helpful AI programming assistant that edits a React + TypeScript project.
+	if template := loadPromptTemplate(opts.Provider, opts.Model); template != "" {
+		return renderPromptTemplate(template, fileStructure, permissions, languageRules(opts.Language, opts.UILibrary), extraRulesSection(opts.ExtraRules), opts.History, instructions, filesJSON)
+	}
+
+	return fmt.Sprintf(`You are a helpful AI programming assistant that edits a React + %s project.
 
 CURRENT PROJECT STRUCTURE:
 %s
@@ -327,6 +1317,7 @@ CRITICAL FILE PATH RULES:
   * For component styles: "src/components/ComponentName.css"
 - DO NOT add extra directories or change the existing structure
 - DO NOT modify the SidePanel.tsx file under any circumstances
+- Files with "read_only": true (such as package.json and tsconfig.json) are reference only: use them to see the available dependencies and compiler settings, but NEVER create, update or delete them
 - When creating new React components, ALWAYS put them in "src/components/" directory
 - EXAMPLES OF CORRECT PATHS: "src/App.tsx", "src/components/Counter.tsx", "src/components/TodoList.tsx"
 - EXAMPLES OF WRONG PATHS: "src/src/App.tsx", "frontend/src/App.tsx", "components/Counter.tsx"
@@ -334,8 +1325,8 @@ CRITICAL FILE PATH RULES:
 IMPORTANT INSTRUCTIONS:
 - Follow the user instructions below precisely.
 - Return ONLY a valid JSON object describing an array of actions.
-- You are allowed to create, update, or delete files.
-- Do not return any text, explanations, or comments outside the JSON.
+%s
+%s- Do not return any text, explanations, or comments outside the JSON.
 - Do not return any other JSON fields, only "actions".
 - Do not return thinking or reasoning steps.
 - Use proper JSON escaping for newlines and quotes.
@@ -343,10 +1334,13 @@ IMPORTANT INSTRUCTIONS:
 - Make sure your JSON is properly formatted and parseable.
 - Each action must be a valid JSON object.
 - Each action must have:
-  - type: "create", "update", or "delete"
+  - type: "create", "update", "delete", or "replace-range"
   - path: a relative file path following the rules above
   - content: full file content (required for create and update; omit for delete)
-
+- For a small change inside a large existing file you may use "replace-range" instead of "update":
+  - start_line and end_line: the 1-based, inclusive line numbers of the lines to replace
+  - content: the new text for just those lines (empty to delete them)
+%s
 Example output:
 
 {
@@ -363,21 +1357,100 @@ Example output:
     }
   ]
 }
-
+%s
 User instructions:
 %s
 
 Project files (JSON array):
 %s
-`, fileStructure, instructions, filesJSON)
+`, languageName(opts.Language), fileStructure, permissions, languageRules(opts.Language, opts.UILibrary), extraRulesSection(opts.ExtraRules), opts.History, instructions, filesJSON)
 }
 
 // Calls OpenRouter API
-func callOpenRouter(prompt string, model string) (string, error) {
-	godotenv.Load() // Load environment variables from .env file
+func callOpenRouter(ctx context.Context, prompt string, model string, n int) ([]string, *TokenUsage, error) {
 	apiKey := os.Getenv("OPENROUTER_API_KEY")
 	if apiKey == "" {
-		return "", fmt.Errorf("OPENROUTER_API_KEY environment variable is not set")
+		return nil, nil, fmt.Errorf("OPENROUTER_API_KEY environment variable is not set")
+	}
+
+	reqBody := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+	}
+
+	if n > 1 {
+		reqBody["n"] = n
+	}
+
+	headers := map[string]string{"Authorization": "Bearer " + apiKey}
+	return callChatCompletionChoices(ctx, "OpenRouter", providerConfig("openrouter"), "https://openrouter.ai/api/v1/chat/completions", headers, reqBody)
+}
+
+// Calls an Azure OpenAI deployment. The model name is used as the deployment name.
+func callAzure(ctx context.Context, prompt string, model string, n int) ([]string, *TokenUsage, error) {
+	apiKey := os.Getenv("AZURE_OPENAI_KEY")
+	if apiKey == "" {
+		return nil, nil, fmt.Errorf("AZURE_OPENAI_KEY environment variable is not set")
+	}
+	endpoint := strings.TrimRight(os.Getenv("AZURE_OPENAI_ENDPOINT"), "/")
+	if endpoint == "" {
+		return nil, nil, fmt.Errorf("AZURE_OPENAI_ENDPOINT environment variable is not set")
+	}
+	apiVersion := os.Getenv("AZURE_OPENAI_API_VERSION")
+	if apiVersion == "" {
+		apiVersion = "2024-06-01"
+	}
+
+	reqBody := map[string]interface{}{
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+	}
+
+	if n > 1 {
+		reqBody["n"] = n
+	}
+
+	endpointURL := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		endpoint, url.PathEscape(model), url.QueryEscape(apiVersion))
+	headers := map[string]string{"api-key": apiKey}
+	return callChatCompletionChoices(ctx, "Azure OpenAI", providerConfig("azure"), endpointURL, headers, reqBody)
+}
+
+// Calls the DeepSeek API, which uses the OpenAI chat completions format
+func callDeepSeek(ctx context.Context, prompt string, model string) (string, *TokenUsage, error) {
+	apiKey := os.Getenv("DEEPSEEK_API_KEY")
+	if apiKey == "" {
+		return "", nil, fmt.Errorf("DEEPSEEK_API_KEY environment variable is not set")
+	}
+
+	reqBody := map[string]interface{}{
+		"model": model,
+		"messages": []map[string]string{
+			{
+				"role":    "user",
+				"content": prompt,
+			},
+		},
+	}
+
+	headers := map[string]string{"Authorization": "Bearer " + apiKey}
+	return callChatCompletions(ctx, "DeepSeek", providerConfig("deepseek"), "https://api.deepseek.com/chat/completions", headers, reqBody)
+}
+
+// Calls the xAI API, which uses the OpenAI chat completions format
+func callGrok(ctx context.Context, prompt string, model string, n int) ([]string, *TokenUsage, error) {
+	apiKey := os.Getenv("XAI_API_KEY")
+	if apiKey == "" {
+		return nil, nil, fmt.Errorf("XAI_API_KEY environment variable is not set")
 	}
 
 	reqBody := map[string]interface{}{
@@ -390,93 +1463,315 @@ func callOpenRouter(prompt string, model string) (string, error) {
 		},
 	}
 
+	if n > 1 {
+		reqBody["n"] = n
+	}
+
+	headers := map[string]string{"Authorization": "Bearer " + apiKey}
+	return callChatCompletionChoices(ctx, "xAI", providerConfig("grok"), "https://api.x.ai/v1/chat/completions", headers, reqBody)
+}
+
+// Sends a request to an OpenAI-compatible chat completions endpoint and
+// returns the raw content of the first choice with the reported usage
+func callChatCompletions(ctx context.Context, providerName string, cfg ProviderConfig, endpointURL string, headers map[string]string, reqBody map[string]interface{}) (string, *TokenUsage, error) {
+	texts, usage, err := callChatCompletionChoices(ctx, providerName, cfg, endpointURL, headers, reqBody)
+	if err != nil {
+		return "", nil, err
+	}
+	return texts[0], usage, nil
+}
+
+// Like callChatCompletions, but returns the content of every choice, for
+// requests that set "n"
+func callChatCompletionChoices(ctx context.Context, providerName string, cfg ProviderConfig, endpointURL string, headers map[string]string, reqBody map[string]interface{}) ([]string, *TokenUsage, error) {
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", err
+		return nil, nil, err
+	}
+
+	// Providers occasionally answer 200 with no choices or an empty message;
+	// asking again usually works, so retry that case separately from HTTP errors
+	emptyRetries := envInt("EMPTY_RESPONSE_RETRIES", 1)
+	for attempt := 0; ; attempt++ {
+		chatResp, err := requestChatCompletion(ctx, providerName, cfg, endpointURL, headers, jsonData)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var usage *TokenUsage
+		if chatResp.Usage != nil {
+			usage = &TokenUsage{PromptTokens: chatResp.Usage.PromptTokens, CompletionTokens: chatResp.Usage.CompletionTokens}
+		}
+
+		problem := "no choices"
+		if len(chatResp.Choices) > 0 {
+			texts := make([]string, len(chatResp.Choices))
+			for i := range chatResp.Choices {
+				texts[i] = chatResp.choiceText(i)
+			}
+			if strings.TrimSpace(texts[0]) != "" || attempt >= emptyRetries {
+				return texts, usage, nil
+			}
+			problem = "empty content"
+		}
+		if attempt >= emptyRetries {
+			return nil, nil, fmt.Errorf("no choices in %s response", providerName)
+		}
+		log.Printf("%s returned %s, asking again (retry %d of %d)", providerName, problem, attempt+1, emptyRetries)
 	}
+}
+
+// Sends a chat completions request, retrying connection errors, 429s and
+// 5xx responses up to cfg.MaxRetries times, and decodes the response
+func requestChatCompletion(ctx context.Context, providerName string, cfg ProviderConfig, endpointURL string, headers map[string]string, jsonData []byte) (*OpenRouterResponse, error) {
+	var resp *http.Response
+	var body []byte
+	var err error
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := retryBackoff(attempt)
+			log.Printf("Retrying %s request in %s (attempt %d of %d)", providerName, delay, attempt+1, cfg.MaxRetries+1)
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+		}
 
-	req, err := http.NewRequest("POST", "https://openrouter.ai/api/v1/chat/completions", bytes.NewBuffer(jsonData))
+		resp, body, err = postChatCompletions(ctx, cfg, endpointURL, headers, jsonData)
+		if attempt >= cfg.MaxRetries || !retryableStatus(resp, err) {
+			break
+		}
+	}
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, chatCompletionsError(providerName, resp, body)
+	}
+
+	var chatResp OpenRouterResponse
+	if err := json.Unmarshal(body, &chatResp); err != nil {
+		return nil, fmt.Errorf("failed to parse %s response: %w", providerName, err)
+	}
+	return &chatResp, nil
+}
+
+// Makes a single chat completions request and reads the whole response body.
+// The request ends early when ctx is done, e.g. when the client disconnects.
+func postChatCompletions(ctx context.Context, cfg ProviderConfig, endpointURL string, headers map[string]string, jsonData []byte) (*http.Response, []byte, error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", endpointURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := providerClient.Do(req)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := readResponseBody(cfg, resp.Body)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
+	return resp, body, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("OpenRouter API error %d: %s", resp.StatusCode, string(body))
-	}
+// Error body returned by OpenRouter and other OpenAI-compatible APIs
+type chatErrorBody struct {
+	Error *struct {
+		Message string      `json:"message"`
+		Code    interface{} `json:"code"` // number on OpenRouter, string on OpenAI
+	} `json:"error"`
+}
 
-	var openRouterResp OpenRouterResponse
-	if err := json.Unmarshal(body, &openRouterResp); err != nil {
-		return "", fmt.Errorf("failed to parse OpenRouter response: %w", err)
+// Turns a non-200 chat completions response into a readable error, using the
+// structured error message when there is one and the raw body otherwise
+func chatCompletionsError(providerName string, resp *http.Response, body []byte) error {
+	var parsed chatErrorBody
+	if err := json.Unmarshal(body, &parsed); err != nil || parsed.Error == nil || parsed.Error.Message == "" {
+		return fmt.Errorf("%s API error %d: %s", providerName, resp.StatusCode, string(body))
 	}
 
-	if len(openRouterResp.Choices) == 0 {
-		return "", fmt.Errorf("no choices in OpenRouter response")
+	message := parsed.Error.Message
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "" {
+			return fmt.Errorf("%s rate limited: %s (try again in %ss)", providerName, message, retryAfter)
+		}
+		return fmt.Errorf("%s rate limited: %s", providerName, message)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%s rejected the API key: %s", providerName, message)
+	case http.StatusNotFound:
+		return fmt.Errorf("%s model not available: %s", providerName, message)
 	}
-
-	return cleanAIResponse(openRouterResp.Choices[0].Message.Content), nil
+	if parsed.Error.Code != nil {
+		return fmt.Errorf("%s API error %d (%v): %s", providerName, resp.StatusCode, parsed.Error.Code, message)
+	}
+	return fmt.Errorf("%s API error %d: %s", providerName, resp.StatusCode, message)
 }
 
 // Calls local Ollama API
-func callOllama(prompt string, model string) (string, error) {
+func callOllama(ctx context.Context, prompt string, model string) (string, *TokenUsage, error) {
+	return generateWithOllama(ctx, prompt, model, envBool("OLLAMA_STREAM", false), nil)
+}
+
+// Sends a generate request to Ollama and returns the raw response text.
+// When streaming, onChunk (if set) receives the text accumulated so far
+// after every chunk.
+func generateWithOllama(ctx context.Context, prompt string, model string, stream bool, onChunk func(string)) (string, *TokenUsage, error) {
 	reqBody := map[string]interface{}{
 		"model":  model,
 		"prompt": prompt,
-		"stream": false,
+		"stream": stream,
 	}
 
 	jsonData, err := json.Marshal(reqBody)
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+
+	cfg := providerConfig("ollama")
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			delay := retryBackoff(attempt)
+			log.Printf("Retrying Ollama request in %s (attempt %d of %d)", delay, attempt+1, cfg.MaxRetries+1)
+			if err := sleepContext(ctx, delay); err != nil {
+				return "", nil, err
+			}
+		}
+
+		text, usage, retryable, err := postOllamaGenerate(ctx, cfg, jsonData, stream, onChunk)
+		if err == nil || !retryable || attempt >= cfg.MaxRetries {
+			return text, usage, err
+		}
+	}
+}
+
+// Makes a single Ollama generate request. Reports whether a failure is worth
+// retrying; streamed responses never are once they have started.
+func postOllamaGenerate(ctx context.Context, cfg ProviderConfig, jsonData []byte, stream bool, onChunk func(string)) (string, *TokenUsage, bool, error) {
+	timeout := cfg.Timeout
+	if stream {
+		timeout = cfg.StreamTimeout
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	req, err := http.NewRequest("POST", "http://localhost:11434/api/generate", bytes.NewBuffer(jsonData))
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:11434/api/generate", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", err
+		return "", nil, false, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	for key, value := range cfg.Headers {
+		req.Header.Set(key, value)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := providerClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to connect to Ollama (make sure it's running on localhost:11434): %w", err)
+		return "", nil, true, fmt.Errorf("failed to connect to Ollama (make sure it's running on localhost:11434): %w", err)
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	if stream && resp.StatusCode == http.StatusOK {
+		var body io.Reader = resp.Body
+		limited := &io.LimitedReader{R: resp.Body, N: cfg.MaxResponseBytes}
+		if cfg.MaxResponseBytes > 0 {
+			body = limited
+		}
+		text, usage, err := readOllamaStream(body, cfg.StreamIdleTimeout, cancel, onChunk)
+		if err != nil && cfg.MaxResponseBytes > 0 && limited.N <= 0 {
+			err = responseTooLargeError(cfg.MaxResponseBytes)
+		}
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("Ollama stream took longer than the %s limit (OLLAMA_STREAM_TIMEOUT): %w", timeout, err)
+		}
+		return text, usage, false, err
+	}
+
+	body, err := readResponseBody(cfg, resp.Body)
 	if err != nil {
-		return "", err
+		return "", nil, !errors.Is(err, errResponseTooLarge), err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("Ollama API error %d: %s", resp.StatusCode, string(body))
+		return "", nil, retryableStatus(resp, nil), fmt.Errorf("Ollama API error %d: %s", resp.StatusCode, string(body))
 	}
 
 	var ollamaResp OllamaResponse
 	if err := json.Unmarshal(body, &ollamaResp); err != nil {
-		return "", fmt.Errorf("failed to parse Ollama response: %w", err)
+		return "", nil, false, fmt.Errorf("failed to parse Ollama response: %w", err)
+	}
+
+	return ollamaResp.Response, ollamaResp.usage(), false, nil
+}
+
+// Reads a streamed Ollama response chunk by chunk until done is true.
+// If no chunk arrives within idleTimeout the request is cancelled, so a
+// model that stalls mid-generation can't hang the edit request forever.
+// An idleTimeout of 0 or less disables the check.
+func readOllamaStream(body io.Reader, idleTimeout time.Duration, cancel context.CancelFunc, onChunk func(string)) (string, *TokenUsage, error) {
+	var stalled atomic.Bool
+	var timer *time.Timer
+	if idleTimeout > 0 {
+		timer = time.AfterFunc(idleTimeout, func() {
+			stalled.Store(true)
+			cancel()
+		})
+		defer timer.Stop()
 	}
 
-	return cleanAIResponse(ollamaResp.Response), nil
+	var text strings.Builder
+	decoder := json.NewDecoder(body)
+	for {
+		var chunk OllamaResponse
+		if err := decoder.Decode(&chunk); err != nil {
+			if stalled.Load() {
+				return "", nil, fmt.Errorf("Ollama stream stalled: no new tokens received for %s", idleTimeout)
+			}
+			if err == io.EOF {
+				return "", nil, fmt.Errorf("Ollama stream ended before the response was done")
+			}
+			return "", nil, fmt.Errorf("failed to read Ollama stream: %w", err)
+		}
+		if timer != nil {
+			timer.Reset(idleTimeout)
+		}
+
+		text.WriteString(chunk.Response)
+		if onChunk != nil {
+			onChunk(text.String())
+		}
+		if chunk.Done {
+			return text.String(), chunk.usage(), nil
+		}
+	}
 }
 
 // Clean up AI response to fix common JSON parsing issues
 func cleanAIResponse(response string) string {
+	// Reasoning models may think out loud before answering
+	response = stripThinking(response)
+
 	// Remove any text before the first {
 	startIndex := strings.Index(response, "{")
 	if startIndex > 0 {
@@ -489,6 +1784,22 @@ func cleanAIResponse(response string) string {
 		response = response[:lastIndex+1]
 	}
 
+	// Optionally tolerate // and /* */ comments some models add despite instructions
+	if envBool("JSONC_TOLERANT", false) {
+		response = stripJSONComments(response)
+	}
+
+	// Raw control characters inside strings. CONTROL_CHARS decides what
+	// happens to ones that aren't whitespace: "escape" (default), "strip",
+	// or "keep" to leave the response alone.
+	switch strings.ToLower(os.Getenv("CONTROL_CHARS")) {
+	case "keep":
+	case "strip":
+		response = sanitizeControlChars(response, true)
+	default:
+		response = sanitizeControlChars(response, false)
+	}
+
 	// Fix common HTML entity escapes that break JSON
 	response = strings.ReplaceAll(response, "\\u003c", "<")
 	response = strings.ReplaceAll(response, "\\u003e", ">")
@@ -506,51 +1817,339 @@ func cleanAIResponse(response string) string {
 	return response
 }
 
+// Runs cleanAIResponse inside its own span
+func tracedCleanAIResponse(ctx context.Context, response string) string {
+	_, span := tracer.Start(ctx, "cleanAIResponse", trace.WithAttributes(attribute.Int("edit.response_bytes", len(response))))
+	defer span.End()
+
+	cleaned := cleanAIResponse(response)
+	span.SetAttributes(attribute.Int("edit.cleaned_bytes", len(cleaned)))
+	return cleaned
+}
+
 // Applies the AI edits to local files
-func applyEdits(edits AIEditActions) error {
+func applyEdits(ctx context.Context, edits AIEditActions, opts ApplyOptions) (*ApplyResult, error) {
+	_, span := tracer.Start(ctx, "applyEdits", trace.WithAttributes(attribute.Int("edit.action_count", len(edits.Actions))))
+	defer span.End()
+
+	progress := progressFrom(ctx)
+	progress.setPhase(phaseApplying)
+
+	// A sandbox copy can't conflict with other people's commits
+	if opts.Root == "" {
+		if err := checkBaseRevision(ctx, opts.BaseRevision, edits); err != nil {
+			recordSpanError(span, err)
+			return nil, err
+		}
+	}
+
+	started := time.Now()
+	entryBefore := snapshotEntry(edits, opts)
+	var result *ApplyResult
+	var err error
+	if size := envInt("APPLY_CHUNK_SIZE", 0); size > 0 && len(edits.Actions) > size {
+		result, err = applyInChunks(ctx, edits, opts, size)
+	} else {
+		result, err = applyEditActions(edits, opts)
+		if result != nil {
+			progress.addApplied(result.Changed)
+		}
+	}
+	if result != nil {
+		result.EntryExport = checkEntryExport(result, entryBefore, opts)
+		result.ApplyMs = durationMs(time.Since(started))
+		span.SetAttributes(attribute.Int("edit.applied", result.Applied), attribute.Int("edit.skipped", len(result.Skipped)))
+	}
+	recordSpanError(span, err)
+	return result, err
+}
+
+func applyEditActions(edits AIEditActions, opts ApplyOptions) (*ApplyResult, error) {
 	log.Printf("Applying %d edit actions", len(edits.Actions))
 
-	for _, act := range edits.Actions {
-		// Normalize the path to prevent incorrect nesting
-		normalizedPath := normalizePath(act.Path)
+	result := &ApplyResult{}
+	skip := func(act EditAction, path, reason string) {
+		log.Printf("Skipping %s %s: %s", act.Type, path, reason)
+		result.Skipped = append(result.Skipped, SkippedAction{Type: act.Type, Path: path, Reason: reason})
+	}
+
+	// STRICT_ACTION_TYPES controls what happens when the model creates a file
+	// that already exists or updates one that doesn't: "reject" skips the
+	// action, "correct" switches it to the right type. Unset leaves it alone.
+	actionTypeMode := strings.ToLower(os.Getenv("STRICT_ACTION_TYPES"))
+	verifyWrites := envBool("VERIFY_WRITES", false)
+	preserveHeaders := envBool("PRESERVE_HEADERS", false)
+	writable := writableExtensions()
+	// DRIFT_MODE decides what happens to an action on a file that changed
+	// after the context was gathered: "skip" (default) or "force"
+	forceDrift := strings.EqualFold(os.Getenv("DRIFT_MODE"), "force")
+	// CREATE_EXISTING_MODE decides what a "create" does to a file that
+	// already exists: "overwrite" (default), "skip" or "confirm"
+	createExistingMode := strings.ToLower(os.Getenv("CREATE_EXISTING_MODE"))
+	duplicates, conflicting := duplicateCreates(edits.Actions)
+	result.DuplicateCreates = conflicting
+
+	for i, act := range edits.Actions {
+		// Normalize the path to prevent incorrect nesting, then apply the
+		// configured rewrites for model-specific mistakes
+		normalizedPath, rewrites := rewriteActionPath(act.Path)
 
 		// Log path changes for debugging
 		if normalizedPath != act.Path {
 			log.Printf("Normalized path: %s -> %s", act.Path, normalizedPath)
 		}
+		if len(rewrites) > 0 {
+			result.PathRewrites = append(result.PathRewrites, PathRewrite{From: act.Path, To: normalizedPath, Rules: rewrites})
+		}
+		if reason, ok := duplicates[i]; ok {
+			skip(act, normalizedPath, reason)
+			continue
+		}
+
+		if !opts.allows(act.Type) {
+			skip(act, normalizedPath, fmt.Sprintf("action type %q is not allowed for this request", act.Type))
+			continue
+		}
 
 		// Prevent editing the SidePanel
 		if strings.Contains(normalizedPath, "SidePanel") {
-			log.Printf("Skipping SidePanel modification: %s", normalizedPath)
+			skip(act, normalizedPath, "SidePanel.tsx is protected")
 			continue
 		}
 
 		// Validate that we're not creating files outside the project
-		if strings.Contains(normalizedPath, "..") || strings.HasPrefix(normalizedPath, "/") {
-			log.Printf("Skipping potentially dangerous path: %s", normalizedPath)
+		if strings.Contains(normalizedPath, "..") || strings.HasPrefix(normalizedPath, "/") || strings.Contains(normalizedPath, ":") {
+			skip(act, normalizedPath, "path is outside the project")
+			continue
+		}
+
+		// Names that are too long or reserved on some filesystems
+		if reason := pathRejection(normalizedPath); reason != "" {
+			skip(act, normalizedPath, reason)
+			continue
+		}
+
+		// Always-included project files are only there for reference
+		if isAlwaysIncluded(normalizedPath) {
+			skip(act, normalizedPath, "reference-only project file")
+			continue
+		}
+		if isReferenceDoc(normalizedPath) {
+			skip(act, normalizedPath, "reference doc, read-only")
+			continue
+		}
+		if reason := scanDirRejection(normalizedPath, opts); reason != "" {
+			skip(act, normalizedPath, reason)
+			continue
+		}
+
+		// Files the model can read but not write, e.g. .env or package-lock.json
+		if writable != nil && !matchesFileType(normalizedPath, writable) {
+			skip(act, normalizedPath, "file type is read-only")
 			continue
 		}
 
 		// Build full path for file operations
-		fullPath := filepath.Join(projectRoot, strings.TrimPrefix(normalizedPath, "src/"))
+		fullPath := opts.filePath(normalizedPath)
+
+		if baseHash, ok := opts.BaseHashes[normalizedPath]; ok {
+			currentHash := ""
+			if current, err := ioutil.ReadFile(fullPath); err == nil {
+				currentHash = contentHash(current)
+			}
+			if currentHash != baseHash {
+				conflict := "file changed after the context was sent to the model"
+				result.Conflicts = append(result.Conflicts, FileIssue{Path: normalizedPath, Issue: conflict})
+				if !forceDrift {
+					skip(act, normalizedPath, conflict)
+					continue
+				}
+				log.Printf("Overwriting %s despite drift (DRIFT_MODE=force)", normalizedPath)
+			}
+		}
+
+		if act.Type == "create" || act.Type == "update" {
+			_, statErr := os.Stat(fullPath)
+			exists := statErr == nil
+			if (act.Type == "create" && exists) || (act.Type == "update" && !exists) {
+				expected := "create"
+				if exists {
+					expected = "update"
+				}
+				switch actionTypeMode {
+				case "reject":
+					skip(act, normalizedPath, fmt.Sprintf("%s used for a file that should be %sd", act.Type, expected))
+					continue
+				case "correct":
+					log.Printf("Correcting action type for %s: %s -> %s", normalizedPath, act.Type, expected)
+					result.Corrections = append(result.Corrections, ActionCorrection{Path: normalizedPath, From: act.Type, To: expected})
+					act.Type = expected
+				}
+			}
+
+			// Models often regenerate a whole file with "create" and drop
+			// unrelated code that was already there
+			if act.Type == "create" && exists && !opts.ConfirmOverwrite {
+				switch createExistingMode {
+				case "skip":
+					skip(act, normalizedPath, "create used for a file that already exists")
+					continue
+				case "confirm":
+					existing, err := ioutil.ReadFile(fullPath)
+					if err != nil {
+						return result, err
+					}
+					act.Path = normalizedPath
+					result.PendingOverwrites = append(result.PendingOverwrites, PendingOverwrite{
+						Action: act,
+						Diff:   unifiedDiff(normalizedPath, string(existing), act.Content, true),
+					})
+					skip(act, normalizedPath, "overwriting an existing file needs confirmation")
+					continue
+				}
+			}
+		}
+
+		// Per-directory structure rules, checked once the action type is final
+		if reason := directoryRuleRejection(act.Type, normalizedPath); reason != "" {
+			skip(act, normalizedPath, reason)
+			continue
+		}
+		if reason := languageRejection(opts.Language, act.Type, normalizedPath); reason != "" {
+			skip(act, normalizedPath, reason)
+			continue
+		}
 
+		started := time.Now()
+		written := 0
 		switch act.Type {
+		case "replace-range":
+			existing, err := ioutil.ReadFile(fullPath)
+			if os.IsNotExist(err) {
+				skip(act, normalizedPath, "replace-range used for a file that doesn't exist")
+				continue
+			}
+			if err != nil {
+				return result, err
+			}
+			replaced, err := replaceLineRange(string(existing), act.StartLine, act.EndLine, act.Content)
+			if err != nil {
+				skip(act, normalizedPath, err.Error())
+				continue
+			}
+			act.Content = replaced
+			fallthrough
 		case "create", "update":
+			if reason := emptyContentRejection(act); reason != "" {
+				skip(act, normalizedPath, reason)
+				continue
+			}
+			// "... existing code ..." comments would wipe the code they stand for
+			original, originalErr := ioutil.ReadFile(fullPath)
+			if originalErr == nil {
+				content, merged, reason := checkElisions(act.Content, string(original))
+				if reason != "" {
+					skip(act, normalizedPath, reason)
+					continue
+				}
+				if merged {
+					log.Printf("Merged elided code back into %s", normalizedPath)
+					act.Content = content
+					result.ElisionsMerged = append(result.ElisionsMerged, normalizedPath)
+				}
+			}
+			if reason := contentRejection(act, string(original)); reason != "" {
+				skip(act, normalizedPath, reason)
+				continue
+			}
+			// Known config files must match their bundled schema
+			if reason := configFileRejection(normalizedPath, act.Content); reason != "" {
+				skip(act, normalizedPath, reason)
+				continue
+			}
+			act.Content = formatJSONContent(normalizedPath, act.Content)
 			if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-				return err
+				return result, err
 			}
-			if err := ioutil.WriteFile(fullPath, []byte(act.Content), 0644); err != nil {
-				return err
+			content := act.Content
+			existing, readErr := ioutil.ReadFile(fullPath)
+			headerRestored := false
+			if preserveHeaders && readErr == nil {
+				if restored, ok := preserveHeader(string(existing), content); ok {
+					content = restored
+					headerRestored = true
+				}
+			}
+
+			data := []byte(content)
+			// Rewriting identical content would only bump the mtime, which
+			// sets off file watchers and rebuilds for nothing
+			if readErr == nil && bytes.Equal(existing, data) {
+				log.Printf("Unchanged file: %s", fullPath)
+				result.Unchanged = append(result.Unchanged, normalizedPath)
+				continue
+			}
+			if headerRestored {
+				log.Printf("Restored file header in %s", normalizedPath)
+				result.HeadersRestored = append(result.HeadersRestored, normalizedPath)
+			}
+			if err := ioutil.WriteFile(fullPath, data, 0644); err != nil {
+				return result, err
+			}
+			written = len(data)
+			if verifyWrites {
+				if err := verifyWrite(fullPath, data); err != nil {
+					log.Printf("Write verification failed for %s: %v", fullPath, err)
+					result.WriteMismatches = append(result.WriteMismatches, FileIssue{Path: normalizedPath, Issue: err.Error()})
+				}
 			}
 			log.Printf("%s file: %s (normalized from: %s)", strings.Title(act.Type), fullPath, act.Path)
 		case "delete":
+			if opts.NoDelete {
+				skip(act, normalizedPath, "deletes are disabled (safe mode)")
+				continue
+			}
+			// Updates are fine, but without these the app won't boot
+			if isEssentialFile(normalizedPath) {
+				skip(act, normalizedPath, "essential file, can't be deleted")
+				continue
+			}
 			if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
-				return err
+				return result, err
 			}
 			log.Printf("Deleted file: %s (normalized from: %s)", fullPath, act.Path)
 		default:
-			log.Printf("Unknown action type: %s", act.Type)
+			skip(act, normalizedPath, "unknown action type")
+			continue
 		}
+		result.Applied++
+		result.Changed = append(result.Changed, normalizedPath)
+		result.recordTiming(act.Type, normalizedPath, written, time.Since(started))
+	}
+	return result, nil
+}
+
+func (r *ApplyResult) recordTiming(actionType, path string, bytes int, elapsed time.Duration) {
+	ms := durationMs(elapsed)
+	log.Printf("%s %s: %d bytes in %.3fms", actionType, path, bytes, ms)
+	r.Timings = append(r.Timings, ActionTiming{Type: actionType, Path: path, Bytes: bytes, DurationMs: ms})
+	r.BytesWritten += bytes
+}
+
+// Milliseconds with microsecond precision, since single writes are often
+// well under a millisecond
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Reads a freshly written file back and checks it matches the intended bytes
+func verifyWrite(fullPath string, want []byte) error {
+	got, err := ioutil.ReadFile(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read file back: %w", err)
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("content on disk differs from what was written (%d bytes on disk, %d expected)", len(got), len(want))
 	}
 	return nil
 }
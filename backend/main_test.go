@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestNormalizePath(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"src/App.tsx", "src/App.tsx"},
+		{`src\App.tsx`, "src/App.tsx"},
+		{`src\components\Foo.tsx`, "src/components/Foo.tsx"},
+		{`src/components\Foo.tsx`, "src/components/Foo.tsx"},
+		{`src\components/Foo.tsx`, "src/components/Foo.tsx"},
+		{`.\src\styles.css`, "src/styles.css"},
+		{`\src\main.tsx`, "src/main.tsx"},
+		{`src\\components\\Foo.tsx`, "src/components/Foo.tsx"},
+		{`src\src\App.tsx`, "src/App.tsx"},
+		{`frontend\src\utils\format.ts`, "src/utils/format.ts"},
+		{`components\Foo.tsx`, "src/components/Foo.tsx"},
+		{`C:\work\frontend\src\App.tsx`, "src/App.tsx"},
+		{`c:\work\frontend\src\components\Foo.tsx`, "src/components/Foo.tsx"},
+		{`D:/work/frontend\src/utils\format.ts`, "src/utils/format.ts"},
+		{`C:\Users\me\Foo.tsx`, "src/components/Foo.tsx"},
+		{`C:src\App.tsx`, "src/App.tsx"},
+	}
+	for _, tt := range tests {
+		if got := normalizePath(tt.in); got != tt.want {
+			t.Errorf("normalizePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Answers with the canned reply from MOCK_RESPONSE, or the file named by
+// MOCK_RESPONSE_FILE, whatever the prompt. Selected with "provider": "mock",
+// it runs the whole /api/edit pipeline (parsing, normalization, safety
+// checks, writing) without calling a real API, so edits are reproducible.
+// Only available with ENABLE_MOCK_PROVIDER=true, so a deployment can't be
+// made to write canned content. Settings are read on every call, so each
+// run can use its own reply.
+func callMock(prompt string, model string) (string, *TokenUsage, error) {
+	if response := os.Getenv("MOCK_RESPONSE"); strings.TrimSpace(response) != "" {
+		return response, nil, nil
+	}
+	file := getenvTrimmed("MOCK_RESPONSE_FILE")
+	if file == "" {
+		return "", nil, fmt.Errorf("MOCK_RESPONSE or MOCK_RESPONSE_FILE must be set for the mock provider")
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading MOCK_RESPONSE_FILE: %w", err)
+	}
+	return string(data), nil, nil
+}
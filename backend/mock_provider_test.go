@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Points projectRoot at a fresh src folder holding files (paths relative
+// to src) for the rest of the test
+func useTempProject(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := filepath.Join(t.TempDir(), "src")
+	for path, content := range files {
+		full := filepath.Join(root, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	previous := projectRoot
+	projectRoot = root
+	t.Cleanup(func() { projectRoot = previous })
+	return root
+}
+
+// Reads every file under root, keyed by its slash path relative to root
+func readTree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	tree := map[string]string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		tree[filepath.ToSlash(rel)] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// POSTs an edit with the mock provider replaying reply and returns the
+// status and decoded response. strict skips the cleanup heuristics, whose
+// "\\n}" repair would otherwise eat the newline before a closing brace.
+func postMockEdit(t *testing.T, reply string, strict bool) (int, map[string]interface{}) {
+	t.Helper()
+	t.Setenv("ENABLE_MOCK_PROVIDER", "true")
+	t.Setenv("MOCK_RESPONSE", reply)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/edit", handleEdit)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"instructions": "apply the canned edit",
+		"provider":     "mock",
+		"model":        "mock",
+		"strict_json":  strict,
+	})
+	resp, err := http.Post(server.URL+"/api/edit", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /api/edit: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var decoded map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+		t.Fatalf("decoding the response: %v", err)
+	}
+	return resp.StatusCode, decoded
+}
+
+// Paths of the response's skipped actions, sorted
+func skippedPaths(response map[string]interface{}) []string {
+	var paths []string
+	skipped, _ := response["skipped"].([]interface{})
+	for _, s := range skipped {
+		if entry, ok := s.(map[string]interface{}); ok {
+			paths = append(paths, entry["path"].(string))
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestEditWithMockProvider(t *testing.T) {
+	const app = "export default function App() {\n  return <h1>Hello</h1>;\n}\n"
+	const sidePanel = "export function SidePanel() {\n  return null;\n}\n"
+	const old = "export const Old = () => null;\n"
+	initial := map[string]string{
+		"App.tsx":                  app,
+		"components/SidePanel.tsx": sidePanel,
+		"components/Old.tsx":       old,
+	}
+
+	const newApp = "export default function App() {\n  return <h1>Bye</h1>;\n}\n"
+	const button = "export const Button = () => <button>OK</button>;\n"
+
+	tests := []struct {
+		name    string
+		actions []EditAction
+		applied int
+		skipped []string
+		// Files expected to differ from initial; "" means deleted
+		changes map[string]string
+	}{
+		{
+			name:    "create",
+			actions: []EditAction{{Type: "create", Path: "src/components/Button.tsx", Content: button}},
+			applied: 1,
+			changes: map[string]string{"components/Button.tsx": button},
+		},
+		{
+			name:    "update",
+			actions: []EditAction{{Type: "update", Path: "src/App.tsx", Content: newApp}},
+			applied: 1,
+			changes: map[string]string{"App.tsx": newApp},
+		},
+		{
+			name:    "delete",
+			actions: []EditAction{{Type: "delete", Path: "src/components/Old.tsx"}},
+			applied: 1,
+			changes: map[string]string{"components/Old.tsx": ""},
+		},
+		{
+			name:    "normalized path",
+			actions: []EditAction{{Type: "create", Path: `frontend\src\components\Button.tsx`, Content: button}},
+			applied: 1,
+			changes: map[string]string{"components/Button.tsx": button},
+		},
+		{
+			name:    "skip protected file",
+			actions: []EditAction{{Type: "update", Path: "src/components/SidePanel.tsx", Content: "export {};\n"}},
+			skipped: []string{"src/components/SidePanel.tsx"},
+		},
+		{
+			name:    "skip path outside the project",
+			actions: []EditAction{{Type: "create", Path: "src/../../evil.ts", Content: "export {};\n"}},
+			skipped: []string{"src/../../evil.ts"},
+		},
+		{
+			name: "mixed batch",
+			actions: []EditAction{
+				{Type: "update", Path: "src/App.tsx", Content: newApp},
+				{Type: "update", Path: "src/components/SidePanel.tsx", Content: "export {};\n"},
+				{Type: "delete", Path: "src/components/Old.tsx"},
+				{Type: "create", Path: "src/components/Button.tsx", Content: button},
+			},
+			applied: 3,
+			skipped: []string{"src/components/SidePanel.tsx"},
+			changes: map[string]string{"App.tsx": newApp, "components/Old.tsx": "", "components/Button.tsx": button},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := useTempProject(t, initial)
+			reply, _ := json.Marshal(AIEditActions{Actions: tt.actions})
+
+			status, response := postMockEdit(t, string(reply), true)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want 200; response %v", status, response)
+			}
+			if applied, _ := response["applied"].(float64); int(applied) != tt.applied {
+				t.Errorf("applied = %v, want %d", response["applied"], tt.applied)
+			}
+			if got := skippedPaths(response); !reflect.DeepEqual(got, tt.skipped) {
+				t.Errorf("skipped = %v, want %v", got, tt.skipped)
+			}
+
+			want := map[string]string{}
+			for path, content := range initial {
+				want[path] = content
+			}
+			for path, content := range tt.changes {
+				if content == "" {
+					delete(want, path)
+				} else {
+					want[path] = content
+				}
+			}
+			if got := readTree(t, root); !reflect.DeepEqual(got, want) {
+				t.Errorf("tree on disk = %q, want %q", got, want)
+			}
+			if _, err := os.Stat(filepath.Join(filepath.Dir(filepath.Dir(root)), "evil.ts")); err == nil {
+				t.Error("a file was written outside the project")
+			}
+		})
+	}
+}
+
+func TestEditWithMockProviderBadReply(t *testing.T) {
+	root := useTempProject(t, map[string]string{"App.tsx": "export default function App() { return null; }\n"})
+	before := readTree(t, root)
+
+	status, response := postMockEdit(t, "Sorry, I can't help with that.", false)
+	if status == http.StatusOK {
+		t.Fatalf("status = 200 for a reply without JSON; response %v", response)
+	}
+	if _, ok := response["error"]; !ok && !strings.Contains(strings.ToLower(response["status"].(string)), "error") {
+		t.Errorf("response %v has no error", response)
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("tree changed after a failed edit: %v, want %v", got, before)
+	}
+}
+
+func TestEditWithMockProviderCleanup(t *testing.T) {
+	root := useTempProject(t, map[string]string{"App.tsx": "export default function App() { return null; }\n"})
+
+	// Prose and a code fence around the JSON, as chat models tend to reply
+	const content = "export const Title = () => <h1>Hi</h1>;\n"
+	reply := "Sure! Here is the edit:\n```json\n" +
+		`{"actions": [{"type": "create", "path": "src/components/Title.tsx", "content": "export const Title = () => \u003ch1\u003eHi\u003c/h1\u003e;\n"}]}` +
+		"\n```\nLet me know if you need anything else."
+
+	status, response := postMockEdit(t, reply, false)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200; response %v", status, response)
+	}
+	got, err := ioutil.ReadFile(filepath.Join(root, "components", "Title.tsx"))
+	if err != nil {
+		t.Fatalf("reading the created file: %v; response %v", err, response)
+	}
+	if string(got) != content {
+		t.Errorf("Title.tsx = %q, want %q", got, content)
+	}
+}
+
+func TestMockProviderDisabled(t *testing.T) {
+	root := useTempProject(t, map[string]string{"App.tsx": "export default function App() { return null; }\n"})
+	before := readTree(t, root)
+	t.Setenv("MOCK_RESPONSE", `{"actions": [{"type": "delete", "path": "src/App.tsx"}]}`)
+
+	body, _ := json.Marshal(map[string]interface{}{"instructions": "apply the canned edit", "provider": "mock", "model": "mock"})
+	rec := httptest.NewRecorder()
+	handleEdit(rec, httptest.NewRequest(http.MethodPost, "/api/edit", bytes.NewReader(body)))
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), errCodeInvalidProvider) {
+		t.Errorf("status = %d, body %s; want 400 %s", rec.Code, rec.Body, errCodeInvalidProvider)
+	}
+	if got := readTree(t, root); !reflect.DeepEqual(got, before) {
+		t.Errorf("tree changed with the mock provider disabled: %v, want %v", got, before)
+	}
+}
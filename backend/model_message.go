@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Text that accepts any JSON value, so a model sending an object or a list
+// where a string belongs doesn't fail the whole parse. Non-strings keep
+// their JSON text.
+type looseText string
+
+func (t *looseText) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*t = looseText(text)
+		return nil
+	}
+	if string(data) == "null" {
+		*t = ""
+		return nil
+	}
+	*t = looseText(data)
+	return nil
+}
+
+// The commentary a model put next to its actions in a top-level "message"
+// or "summary" field, despite the prompt asking for actions only. Returned
+// to the client as "model_message" unless MODEL_MESSAGE=discard. "" when
+// there is none.
+func (e AIEditActions) modelMessage() string {
+	if strings.EqualFold(getenvTrimmed("MODEL_MESSAGE"), "discard") {
+		return ""
+	}
+	message := strings.TrimSpace(string(e.Message))
+	summary := strings.TrimSpace(string(e.Summary))
+	switch {
+	case message == "" || message == summary:
+		return summary
+	case summary == "":
+		return message
+	}
+	return message + "\n\n" + summary
+}
+
+// Adds the model's commentary on the edits to an endpoint's response, if any
+func addModelMessage(response map[string]interface{}, edits AIEditActions) {
+	if message := edits.modelMessage(); message != "" {
+		response["model_message"] = message
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Model lists used when a provider's live list can't be fetched
+var defaultModels = map[string][]string{
+	"openrouter": {
+		"qwen/qwen-2.5-7b-instruct:free",
+		"qwen/qwen3-30b-a3b:free",
+		"meta-llama/llama-3.1-8b-instruct:free",
+		"anthropic/claude-3.5-sonnet",
+		"openai/gpt-4o",
+		"openai/gpt-4o-mini",
+		"google/gemini-pro-1.5",
+		"qwen/qwen-2.5-72b-instruct",
+	},
+	"ollama": {
+		"llama3.2",
+		"qwen2.5",
+		"codellama",
+		"deepseek-coder",
+		"starcoder2",
+	},
+	// Azure deployment names are chosen by the user, see AZURE_OPENAI_DEPLOYMENTS
+	"azure": {},
+	"deepseek": {
+		"deepseek-chat",
+		"deepseek-coder",
+		"deepseek-reasoner",
+	},
+	"grok": {
+		"grok-4",
+		"grok-3",
+		"grok-3-mini",
+		"grok-code-fast-1",
+	},
+}
+
+// Functions that fetch the live model list of each provider
+var modelFetchers = map[string]func() ([]string, error){
+	"openrouter": fetchOpenRouterModels,
+	"ollama":     fetchOllamaModels,
+	"azure":      listAzureDeployments,
+}
+
+// Caches the model lists so /api/models doesn't hit every provider on each call
+type modelCache struct {
+	mu        sync.Mutex
+	models    map[string][]string
+	fetchedAt time.Time
+}
+
+var modelLists = &modelCache{}
+
+// Returns the cached model lists, fetching them if missing or older than MODELS_CACHE_TTL
+func (c *modelCache) get() map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := envDuration("MODELS_CACHE_TTL", 10*time.Minute)
+	if c.models == nil || time.Since(c.fetchedAt) > ttl {
+		c.models = fetchModelLists()
+		c.fetchedAt = time.Now()
+	}
+	return c.models
+}
+
+// Drops the cached lists and fetches them again immediately
+func (c *modelCache) refresh() map[string][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.models = fetchModelLists()
+	c.fetchedAt = time.Now()
+	return c.models
+}
+
+// Fetches every provider's model list, falling back to the defaults on error
+func fetchModelLists() map[string][]string {
+	lists := make(map[string][]string, len(defaultModels))
+	for provider, fallback := range defaultModels {
+		fetch, ok := modelFetchers[provider]
+		if !ok {
+			lists[provider] = fallback
+			continue
+		}
+		models, err := fetch()
+		if err != nil || len(models) == 0 {
+			if err != nil {
+				log.Printf("Using default %s models: %v", provider, err)
+			}
+			lists[provider] = fallback
+			continue
+		}
+		lists[provider] = models
+	}
+	return lists
+}
+
+// Lists the models currently offered by OpenRouter
+func fetchOpenRouterModels() ([]string, error) {
+	var payload struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getModelListJSON("https://openrouter.ai/api/v1/models", &payload); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(payload.Data))
+	for _, m := range payload.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+// Lists the models pulled into the local Ollama instance
+func fetchOllamaModels() ([]string, error) {
+	var payload struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := getModelListJSON("http://localhost:11434/api/tags", &payload); err != nil {
+		return nil, err
+	}
+
+	models := make([]string, 0, len(payload.Models))
+	for _, m := range payload.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
+
+// Lists the Azure OpenAI deployments configured in AZURE_OPENAI_DEPLOYMENTS
+func listAzureDeployments() ([]string, error) {
+	return envList("AZURE_OPENAI_DEPLOYMENTS"), nil
+}
+
+// Short names for full model IDs, configured as MODEL_ALIASES="qwen-big=qwen/qwen-2.5-72b-instruct,fast=openai/gpt-4o-mini"
+func modelAliases() map[string]string {
+	aliases := map[string]string{}
+	for _, entry := range envList("MODEL_ALIASES") {
+		alias, model, ok := strings.Cut(entry, "=")
+		alias, model = strings.TrimSpace(alias), strings.TrimSpace(model)
+		if !ok || alias == "" || model == "" {
+			log.Printf("Ignoring invalid MODEL_ALIASES entry: %q", entry)
+			continue
+		}
+		aliases[alias] = model
+	}
+	return aliases
+}
+
+// Returns the full model ID for an alias, or the model unchanged
+func resolveModelAlias(model string) string {
+	if full, ok := modelAliases()[model]; ok {
+		return full
+	}
+	return model
+}
+
+// Model lists keyed by provider plus the configured aliases, as returned
+// by /api/models. Providers missing a required setting are left out, so
+// the frontend doesn't offer them; what they miss is logged at startup.
+func modelsResponse(lists map[string][]string) map[string]interface{} {
+	response := make(map[string]interface{}, len(lists)+1)
+	for provider, models := range lists {
+		if len(missingProviderEnv(provider)) > 0 {
+			continue
+		}
+		response[provider] = models
+	}
+	response["aliases"] = modelAliases()
+	return response
+}
+
+func getModelListJSON(url string, v interface{}) error {
+	resp, err := metadataClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("model list request to %s failed with %d", url, resp.StatusCode)
+	}
+	return json.Unmarshal(body, v)
+}
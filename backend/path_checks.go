@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Longest action path accepted unless MAX_PATH_LENGTH says otherwise. Stays
+// well below Windows' 260 character MAX_PATH once the project root is added.
+const defaultMaxPathLength = 200
+
+// Longest single file or directory name most filesystems allow
+const maxPathSegmentLength = 255
+
+// Device names Windows reserves regardless of extension, e.g. "con.tsx"
+var reservedBaseNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true,
+	"com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true,
+	"lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// Checks a normalized action path for names that can't be written on every
+// platform and returns why it must be rejected, or "" when it is fine
+func pathRejection(normalizedPath string) string {
+	if limit := envInt("MAX_PATH_LENGTH", defaultMaxPathLength); limit > 0 && len(normalizedPath) > limit {
+		return fmt.Sprintf("path is %d characters, over the %d character limit", len(normalizedPath), limit)
+	}
+
+	for _, segment := range strings.Split(normalizedPath, "/") {
+		if len(segment) > maxPathSegmentLength {
+			return fmt.Sprintf("name %.20q... is longer than %d characters", segment, maxPathSegmentLength)
+		}
+		if strings.HasSuffix(segment, ".") || strings.HasSuffix(segment, " ") {
+			return fmt.Sprintf("name %q ends with a dot or space", segment)
+		}
+		if i := strings.IndexAny(segment, `<>"|?*`); i >= 0 {
+			return fmt.Sprintf("name %q contains the reserved character %q", segment, segment[i])
+		}
+		for _, r := range segment {
+			if r < 0x20 {
+				return fmt.Sprintf("name %q contains a control character", segment)
+			}
+		}
+
+		base := strings.ToLower(segment)
+		if dot := strings.Index(base, "."); dot >= 0 {
+			base = base[:dot]
+		}
+		if reservedBaseNames[base] {
+			return fmt.Sprintf("name %q is reserved on Windows", segment)
+		}
+	}
+	return ""
+}
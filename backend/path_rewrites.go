@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Separates the pattern from the replacement in a rewrite rule
+const pathRewriteArrow = "=>"
+
+// A path rewrite applied to the model's action paths after normalizePath
+type pathRewriteRule struct {
+	text    string // as written in the configuration, reported with each rewrite
+	pattern *regexp.Regexp
+	replace string
+}
+
+// A path the model returned that a rewrite rule changed
+type PathRewrite struct {
+	From  string   `json:"from"` // as the model wrote it
+	To    string   `json:"to"`
+	Rules []string `json:"rules"` // the rules that matched, in the order applied
+}
+
+// Caches the parsed rewrite rules, since they are needed for every action
+// path; they are parsed again when PATH_REWRITES, PATH_REWRITES_FILE or the
+// file's modification time changes
+type pathRewriteCache struct {
+	mu       sync.Mutex
+	key      string
+	modified time.Time
+	rules    []pathRewriteRule
+}
+
+var pathRewrites = &pathRewriteCache{}
+
+// Collects the rewrite rules from PATH_REWRITES (entries separated by ";",
+// since patterns often contain commas) and PATH_REWRITES_FILE (one per line,
+// "#" for comments). Each rule is "pattern => replacement", e.g.
+// "^src/app\.tsx$ => src/App.tsx"; the replacement may use $1 for groups.
+// Invalid rules are logged and ignored.
+func pathRewriteRules() []pathRewriteRule {
+	return pathRewrites.get()
+}
+
+func (c *pathRewriteCache) get() []pathRewriteRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	inline := os.Getenv("PATH_REWRITES")
+	file := strings.TrimSpace(os.Getenv("PATH_REWRITES_FILE"))
+	var modified time.Time
+	if file != "" {
+		if info, err := os.Stat(file); err == nil {
+			modified = info.ModTime()
+		}
+	}
+	key := inline + "\x00" + file
+	if c.rules != nil && key == c.key && modified.Equal(c.modified) {
+		return c.rules
+	}
+
+	c.rules = parsePathRewriteRules(inline, file)
+	c.key, c.modified = key, modified
+	return c.rules
+}
+
+func parsePathRewriteRules(inline, file string) []pathRewriteRule {
+	entries := strings.Split(inline, ";")
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Printf("Ignoring PATH_REWRITES_FILE: %v", err)
+		} else {
+			for _, line := range strings.Split(string(data), "\n") {
+				if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+					entries = append(entries, line)
+				}
+			}
+		}
+	}
+
+	rules := []pathRewriteRule{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		source, replace, ok := strings.Cut(entry, pathRewriteArrow)
+		source, replace = strings.TrimSpace(source), strings.TrimSpace(replace)
+		if !ok || source == "" {
+			log.Printf("Ignoring path rewrite %q (want pattern => replacement)", entry)
+			continue
+		}
+		pattern, err := compileContentRegex(source)
+		if err != nil {
+			log.Printf("Ignoring path rewrite %q: %v", entry, err)
+			continue
+		}
+		rules = append(rules, pathRewriteRule{text: entry, pattern: pattern, replace: replace})
+	}
+	return rules
+}
+
+// Normalizes an action path the model returned and applies the rewrite
+// rules in order. Returns the final path and the rules that changed it.
+func rewriteActionPath(path string) (string, []string) {
+	rewritten := normalizePath(path)
+	var applied []string
+	for _, rule := range pathRewriteRules() {
+		if !rule.pattern.MatchString(rewritten) {
+			continue
+		}
+		next := rule.pattern.ReplaceAllString(rewritten, rule.replace)
+		if next != rewritten {
+			rewritten = next
+			applied = append(applied, rule.text)
+		}
+	}
+	return rewritten, applied
+}
+
+// The path an action from the model is applied to: normalized, then
+// rewritten by PATH_REWRITES
+func actionPath(path string) string {
+	rewritten, _ := rewriteActionPath(path)
+	return rewritten
+}
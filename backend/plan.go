@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// One file the model intends to change, as proposed by /api/plan
+type PlanStep struct {
+	Action string `json:"action"` // "create", "update" or "delete"
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+// The model's reply to a plan prompt
+type EditPlan struct {
+	Summary string     `json:"summary,omitempty"`
+	Steps   []PlanStep `json:"plan"`
+}
+
+// Asks the model only for a plan of which files to change and why, so the
+// user can review it before any code is generated
+func handlePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	var req EditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if req.SessionID == "" {
+		req.SessionID = sessionKey(r)
+	}
+
+	plan, usage, err := generatePlan(r.Context(), req)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status": "success",
+		"plan":   plan.Steps,
+	}
+	if plan.Summary != "" {
+		response["summary"] = plan.Summary
+	}
+	if usage != nil {
+		response["usage"] = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Generates and applies edits that follow an approved plan. Takes the same
+// body as /api/edit with the "plan" returned by /api/plan.
+func handleExecutePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	var req EditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if len(req.Plan) == 0 {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "plan is required; get one from /api/plan first", nil)
+		return
+	}
+	if req.SessionID == "" {
+		req.SessionID = sessionKey(r)
+	}
+
+	serveEdit(w, r, req)
+}
+
+// Gathers the project context and asks the provider for a plan
+func generatePlan(ctx context.Context, req EditRequest) (*EditPlan, *TokenUsage, error) {
+	req.Model = resolveModelAlias(req.Model)
+
+	filesJSON, _, omitted, err := gatherPromptContext(ctx, req.Instructions, req.TargetFiles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instructions, err := expandInstructions(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	instructions += targetFilesNote(req.TargetFiles)
+	aiResponse, usage, err := callProvider(ctx, req, buildPlanPrompt(instructions, filesJSON, omitted, projectLanguage(req), uiLibrary(req)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleanedResponse := tracedCleanAIResponse(ctx, aiResponse)
+	last := &LastResponse{
+		Provider:   req.Provider,
+		Model:      req.Model,
+		Raw:        aiResponse,
+		Cleaned:    cleanedResponse,
+		Parsed:     true,
+		ReceivedAt: time.Now(),
+	}
+	defer lastResponses.record(req.SessionID, last)
+
+	var plan EditPlan
+	if err := json.Unmarshal([]byte(cleanedResponse), &plan); err != nil {
+		last.Parsed = false
+		last.ParseError = err.Error()
+		log.Printf("Failed to parse plan as JSON: %v", err)
+		if truncated := truncationError(aiResponse); truncated != nil {
+			last.ParseError = truncated.Error()
+			return nil, nil, truncated
+		}
+		return nil, nil, newAPIError(http.StatusBadGateway, errCodeParseFailed, fmt.Sprintf("Failed to parse plan as JSON: %v", err), map[string]string{"original_response": aiResponse})
+	}
+	for i := range plan.Steps {
+		plan.Steps[i].Path = actionPath(plan.Steps[i].Path)
+	}
+	return &plan, usage, nil
+}
+
+// Builds the prompt asking for a plan instead of file contents. The
+// language rules keep planned paths to the project's file extensions.
+func buildPlanPrompt(instructions string, filesJSON string, omitted []string, language, library string) string {
+	return fmt.Sprintf(`You are a helpful AI programming assistant planning changes to a React + %s project.
+
+CURRENT PROJECT STRUCTURE:
+%s
+
+Do NOT write any code yet. Decide which files must be created, updated or
+deleted to carry out the user instructions, and why.
+
+IMPORTANT INSTRUCTIONS:
+- Return ONLY a valid JSON object, with no text outside it.
+- Use paths relative to the project root, e.g. "src/App.tsx" or "src/components/Counter.tsx".
+- NEVER plan changes to src/components/SidePanel.tsx or to read-only files.
+- List each file once.
+%s
+Example output:
+
+{
+  "summary": "Add a counter component and show it in the app",
+  "plan": [
+    { "action": "create", "path": "src/components/Counter.tsx", "reason": "New counter component" },
+    { "action": "update", "path": "src/App.tsx", "reason": "Render the counter" }
+  ]
+}
+
+User instructions:
+%s
+
+Project files (JSON array):
+%s
+`, languageName(language), extractFileStructure(filesJSON)+omittedFilesNote(omitted)+referenceDocsSection(), languageRules(language, library), instructions, filesJSON)
+}
+
+// Appended to the instructions when executing an approved plan
+func approvedPlanText(steps []PlanStep) string {
+	var b strings.Builder
+	b.WriteString("\n\nThe user approved this plan. Make exactly these changes and no others:\n")
+	for _, step := range steps {
+		fmt.Fprintf(&b, "- %s %s", step.Action, step.Path)
+		if step.Reason != "" {
+			fmt.Fprintf(&b, ": %s", step.Reason)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+)
+
+// Static files for the optional review UI (enabled with SERVE_UI=true)
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// Proposed change to a single file, shown before anything is written
+type PreviewFile struct {
+	Type string `json:"type"`
+	Path string `json:"path"`
+	Diff string `json:"diff"`
+}
+
+// Asks the model for edits and returns them with per-file diffs without applying
+func handlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	var req EditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if req.SessionID == "" {
+		req.SessionID = sessionKey(r)
+	}
+
+	gen, err := generateEdits(r.Context(), req)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":  "success",
+		"actions": gen.Edits.Actions,
+		"files":   previewEdits(gen.Edits),
+		// Send these back to /api/apply to detect files changed in the meantime
+		"base_hashes":   gen.BaseHashes,
+		"base_revision": gen.BaseRevision,
+		"provider":      gen.Provider,
+		"model":         gen.Model,
+	}
+	addModelMessage(response, gen.Edits)
+	var proposed []string
+	for _, act := range gen.Edits.Actions {
+		proposed = append(proposed, actionPath(act.Path))
+	}
+	addContextScope(response, gen.BaseHashes, proposed)
+	if gen.Usage != nil {
+		response["usage"] = gen.Usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Applies a previously previewed set of actions as-is, without calling the
+// model. The body may carry the same options as an edit request.
+func handleApply(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	var body struct {
+		AIEditActions
+		EditRequest
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	ctx, branch, err := startEditBranch(r.Context())
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	defer branch.finish()
+
+	opts := applyOptionsFor(body.EditRequest)
+	branch.applyTo(&opts)
+	result, err := applyEdits(ctx, body.AIEditActions, opts)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeAuditManifest("/api/apply", body.EditRequest, body.Actions, result)
+
+	response := buildApplyResponse(result, opts)
+	if body.IncludeContent {
+		response["files"] = appliedFiles(result.Changed, body.KnownFiles, opts)
+	}
+	if branch != nil {
+		branch.commit(ctx, result.Changed, body.Instructions)
+		response["branch"] = branch
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Diffs each action against the current file contents
+func previewEdits(edits AIEditActions) []PreviewFile {
+	files := make([]PreviewFile, 0, len(edits.Actions))
+	for _, act := range edits.Actions {
+		path := actionPath(act.Path)
+
+		before, err := ioutil.ReadFile(projectFilePath(path))
+		existed := err == nil
+
+		after := act.Content
+		switch act.Type {
+		case "delete":
+			after = ""
+		case "replace-range":
+			replaced, err := replaceLineRange(string(before), act.StartLine, act.EndLine, act.Content)
+			if err != nil {
+				replaced = string(before)
+			}
+			after = replaced
+		}
+
+		files = append(files, PreviewFile{
+			Type: act.Type,
+			Path: path,
+			Diff: unifiedDiff(path, string(before), after, existed),
+		})
+	}
+	return files
+}
+
+// Serves the embedded review UI
+func uiHandler() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
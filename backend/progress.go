@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Phases an edit goes through, as reported by /api/progress
+const (
+	phaseGathering = "gathering_context"
+	phaseWaiting   = "waiting_for_model"
+	phaseApplying  = "applying"
+	phaseDone      = "done"
+	phaseFailed    = "failed"
+)
+
+// How long finished edits stay available to /api/progress
+const progressRetention = 10 * time.Minute
+
+// Longest a single /api/progress call waits for a change
+const maxProgressWait = 30 * time.Second
+
+// Progress of one in-flight edit, identified by the client's request ID
+type EditProgress struct {
+	ID           string   `json:"id"`
+	Phase        string   `json:"phase"`
+	Step         int      `json:"step,omitempty"` // 1-based batch step, 0 outside batches
+	Steps        int      `json:"steps,omitempty"`
+	FilesApplied []string `json:"files_applied"`
+	// Actions handled so far out of the batch, set when APPLY_CHUNK_SIZE
+	// splits it up
+	ActionsApplied int       `json:"actions_applied,omitempty"`
+	ActionsTotal   int       `json:"actions_total,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	Version        int       `json:"version"` // increases with every update; pass as ?since= to wait for the next
+	StartedAt      time.Time `json:"started_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Tracks one edit. All methods are safe on a nil tracker, which is what
+// edits without a request ID get.
+type progressTracker struct {
+	mu      sync.Mutex
+	state   EditProgress
+	changed chan struct{} // closed and replaced on every update
+}
+
+type progressStore struct {
+	mu       sync.Mutex
+	trackers map[string]*progressTracker
+}
+
+var editProgress = &progressStore{trackers: map[string]*progressTracker{}}
+
+type progressContextKey struct{}
+
+// Starts tracking the edit behind r when the client gave it an ID (the
+// request_id field or the X-Request-ID header) and returns a context
+// carrying the tracker
+func startProgress(r *http.Request, req EditRequest) (context.Context, *progressTracker) {
+	id := req.RequestID
+	if id == "" {
+		id = r.Header.Get("X-Request-ID")
+	}
+	if id == "" {
+		return r.Context(), nil
+	}
+
+	tracker := editProgress.start(id)
+	return context.WithValue(r.Context(), progressContextKey{}, tracker), tracker
+}
+
+// The tracker carried by ctx, or nil
+func progressFrom(ctx context.Context) *progressTracker {
+	tracker, _ := ctx.Value(progressContextKey{}).(*progressTracker)
+	return tracker
+}
+
+func (s *progressStore) start(id string) *progressTracker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget edits that finished a while ago
+	for key, tracker := range s.trackers {
+		tracker.mu.Lock()
+		expired := time.Since(tracker.state.UpdatedAt) > progressRetention
+		tracker.mu.Unlock()
+		if expired {
+			delete(s.trackers, key)
+		}
+	}
+
+	now := time.Now()
+	tracker := &progressTracker{
+		state:   EditProgress{ID: id, Phase: phaseGathering, FilesApplied: []string{}, StartedAt: now, UpdatedAt: now},
+		changed: make(chan struct{}),
+	}
+	s.trackers[id] = tracker
+	return tracker
+}
+
+func (s *progressStore) get(id string) *progressTracker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.trackers[id]
+}
+
+func (t *progressTracker) update(change func(*EditProgress)) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	change(&t.state)
+	t.state.Version++
+	t.state.UpdatedAt = time.Now()
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+func (t *progressTracker) setPhase(phase string) {
+	t.update(func(p *EditProgress) { p.Phase = phase })
+}
+
+func (t *progressTracker) setStep(step, steps int) {
+	t.update(func(p *EditProgress) { p.Step, p.Steps = step, steps })
+}
+
+func (t *progressTracker) setActions(applied, total int) {
+	t.update(func(p *EditProgress) { p.ActionsApplied, p.ActionsTotal = applied, total })
+}
+
+func (t *progressTracker) addApplied(paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	t.update(func(p *EditProgress) { p.FilesApplied = append(p.FilesApplied, paths...) })
+}
+
+// Marks the edit as finished, failed when err is non-nil
+func (t *progressTracker) finish(err error) {
+	t.update(func(p *EditProgress) {
+		p.Phase = phaseDone
+		if err != nil {
+			p.Phase = phaseFailed
+			p.Error = err.Error()
+		}
+	})
+}
+
+// Returns the current state once its version is above since, waiting up to
+// wait for an update
+func (t *progressTracker) snapshot(since int, wait time.Duration) EditProgress {
+	t.mu.Lock()
+	state, changed := t.state, t.changed
+	t.mu.Unlock()
+
+	if state.Version > since || state.Phase == phaseDone || state.Phase == phaseFailed || wait <= 0 {
+		return copyProgress(state)
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-changed:
+	case <-timer.C:
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return copyProgress(t.state)
+}
+
+// Copies the applied file list so the caller can encode it without the lock
+func copyProgress(state EditProgress) EditProgress {
+	state.FilesApplied = append([]string{}, state.FilesApplied...)
+	return state
+}
+
+// Long-poll progress for an edit started with a request ID.
+// GET /api/progress?id=<id>[&since=<version>&wait=<duration>] returns at
+// once when the state is newer than since, otherwise waits up to wait
+// (default 20s, at most 30s) for the next update.
+func handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+
+	query := r.URL.Query()
+	id := query.Get("id")
+	if id == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "id query parameter is required", nil)
+		return
+	}
+	tracker := editProgress.get(id)
+	if tracker == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No edit in progress with this id", nil)
+		return
+	}
+
+	since := -1
+	if value := query.Get("since"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "since must be a number", nil)
+			return
+		}
+		since = parsed
+	}
+	wait := 20 * time.Second
+	if value := query.Get("wait"); value != "" {
+		parsed, err := parseDuration(value)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "wait must be a duration such as 10s", nil)
+			return
+		}
+		wait = parsed
+	}
+	if wait > maxProgressWait {
+		wait = maxProgressWait
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tracker.snapshot(since, wait))
+}
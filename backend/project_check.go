@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Stops the walk once a source file has been found
+var errFoundSource = errors.New("found source file")
+
+// Checks that projectRoot looks like the src/ folder of a React project:
+// it must exist and either contain .tsx/.jsx/.ts files or sit next to a
+// package.json. Guards against walking (and editing) the wrong directory.
+func checkProjectRoot() error {
+	info, err := os.Stat(projectRoot)
+	if err != nil {
+		return fmt.Errorf("project root %s: %w", projectRoot, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("project root %s is not a directory", projectRoot)
+	}
+
+	if _, err := os.Stat(filepath.Join(filepath.Dir(projectRoot), "package.json")); err == nil {
+		return nil
+	}
+
+	sourceTypes := []string{".tsx", ".jsx", ".ts"}
+	err = filepath.WalkDir(projectRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "node_modules" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && matchesFileType(path, sourceTypes) {
+			return errFoundSource
+		}
+		return nil
+	})
+	if err == errFoundSource {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("project root %s: %w", projectRoot, err)
+	}
+	return fmt.Errorf("project root %s has no .tsx/.jsx/.ts files and no package.json next to it; it doesn't look like a React src folder", projectRoot)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// In-memory copy of the project files, kept current by watching projectRoot,
+// so repeated context gathers only re-read files that changed
+type projectIndex struct {
+	mu      sync.Mutex
+	files   map[string]*indexedFile // path relative to projectRoot -> file
+	stale   bool                    // events were lost; rebuild on next use
+	watcher *fsnotify.Watcher
+}
+
+type indexedFile struct {
+	content []byte
+	hash    string
+	loaded  bool // false until read, and again after the file changes
+}
+
+var (
+	contextIndexOnce sync.Once
+	contextIndex     *projectIndex // nil unless CONTEXT_INDEX is on and watching works
+)
+
+// Returns the shared index, starting it on first use. Returns nil when
+// CONTEXT_INDEX is off or the watcher can't start, so callers walk the tree.
+func projectContextIndex() *projectIndex {
+	if !envBool("CONTEXT_INDEX", false) {
+		return nil
+	}
+	contextIndexOnce.Do(func() {
+		idx, err := newProjectIndex()
+		if err != nil {
+			log.Printf("Context index disabled: %v", err)
+			return
+		}
+		contextIndex = idx
+	})
+	return contextIndex
+}
+
+func newProjectIndex() (*projectIndex, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	idx := &projectIndex{files: map[string]*indexedFile{}, watcher: watcher}
+	if err := idx.scan(projectRoot); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go idx.watch()
+	return idx, nil
+}
+
+// Registers every file under dir and watches every directory. Callers other
+// than newProjectIndex must hold mu.
+func (idx *projectIndex) scan(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return idx.watcher.Add(path)
+		}
+		if rel, ok := indexRelPath(path); ok {
+			idx.files[rel] = &indexedFile{}
+		}
+		return nil
+	})
+}
+
+func (idx *projectIndex) watch() {
+	for {
+		select {
+		case event, ok := <-idx.watcher.Events:
+			if !ok {
+				return
+			}
+			idx.handle(event)
+		case err, ok := <-idx.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Usually a queue overflow: some changes were missed
+			log.Printf("Context index watcher error, will rebuild: %v", err)
+			idx.mu.Lock()
+			idx.stale = true
+			idx.mu.Unlock()
+		}
+	}
+}
+
+func (idx *projectIndex) handle(event fsnotify.Event) {
+	rel, ok := indexRelPath(event.Name)
+	if !ok {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	switch {
+	case event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename):
+		// The path may have been a directory, so drop everything under it too
+		prefix := rel + string(os.PathSeparator)
+		for path := range idx.files {
+			if path == rel || strings.HasPrefix(path, prefix) {
+				delete(idx.files, path)
+			}
+		}
+	case event.Has(fsnotify.Create):
+		info, err := os.Stat(event.Name)
+		if err != nil {
+			return
+		}
+		if info.IsDir() {
+			if err := idx.scan(event.Name); err != nil {
+				log.Printf("Context index failed to watch %s, will rebuild: %v", event.Name, err)
+				idx.stale = true
+			}
+			return
+		}
+		idx.files[rel] = &indexedFile{}
+	case event.Has(fsnotify.Write):
+		if file, ok := idx.files[rel]; ok {
+			file.loaded = false
+		} else {
+			idx.files[rel] = &indexedFile{}
+		}
+	}
+}
+
+// Returns the indexed files matching the given extensions, sorted by path,
+// reading only those that changed since the last call
+func (idx *projectIndex) contextFiles(types []string) ([]FileJSON, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.stale {
+		log.Printf("Rebuilding context index")
+		idx.files = map[string]*indexedFile{}
+		if err := idx.scan(projectRoot); err != nil {
+			return nil, err
+		}
+		idx.stale = false
+	}
+
+	paths := make([]string, 0, len(idx.files))
+	for path := range idx.files {
+		if matchesFileType(path, types) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	files := make([]FileJSON, 0, len(paths))
+	for _, path := range paths {
+		file := idx.files[path]
+		if !file.loaded {
+			content, err := ioutil.ReadFile(filepath.Join(projectRoot, path))
+			if os.IsNotExist(err) {
+				// Removed and the event hasn't arrived yet
+				delete(idx.files, path)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			file.content = content
+			file.hash = contentHash(content)
+			file.loaded = true
+		}
+		files = append(files, FileJSON{Path: path, Content: string(file.content), Hash: file.hash})
+	}
+	return files, nil
+}
+
+// Path relative to projectRoot, or false for projectRoot itself and paths outside it
+func indexRelPath(path string) (string, bool) {
+	rel, err := filepath.Rel(projectRoot, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", false
+	}
+	return rel, true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+)
+
+// Placeholders a prompt template fills in. Templates must keep
+// {{instructions}} and {{files}}; the others are optional.
+const (
+	placeholderStructure    = "{{structure}}"
+	placeholderPermissions  = "{{permissions}}"
+	placeholderLanguage     = "{{language_rules}}"
+	placeholderExtraRules   = "{{extra_rules}}"
+	placeholderHistory      = "{{history}}"
+	placeholderInstructions = "{{instructions}}"
+	placeholderFiles        = "{{files}}"
+)
+
+// Picks the prompt template file for a provider and model from
+// PROMPT_TEMPLATES, e.g.
+// "ollama=prompts/small.txt,openrouter:anthropic/*=prompts/claude.txt".
+// Selectors are "provider", "provider:model" or "provider:model-prefix*";
+// an exact model beats the longest prefix, which beats the bare provider.
+// Returns "" to use the built-in prompt.
+func promptTemplateFile(provider, model string) string {
+	best, bestRank := "", 0
+	for _, entry := range envList("PROMPT_TEMPLATES") {
+		selector, file, ok := strings.Cut(entry, "=")
+		selector, file = strings.TrimSpace(selector), strings.TrimSpace(file)
+		if !ok || selector == "" || file == "" {
+			log.Printf("Ignoring PROMPT_TEMPLATES entry %q (want selector=file)", entry)
+			continue
+		}
+
+		entryProvider, entryModel, hasModel := strings.Cut(selector, ":")
+		if !strings.EqualFold(entryProvider, provider) {
+			continue
+		}
+		rank := 1
+		if hasModel {
+			prefix, isPrefix := strings.CutSuffix(entryModel, "*")
+			switch {
+			case !isPrefix && entryModel == model:
+				rank = 1 << 30
+			case isPrefix && strings.HasPrefix(model, prefix):
+				rank = 2 + len(prefix)
+			default:
+				continue
+			}
+		}
+		if rank > bestRank {
+			best, bestRank = file, rank
+		}
+	}
+	return best
+}
+
+// Reads the template for a provider and model. Returns "" when none is
+// configured or the template can't be used, so the built-in prompt applies.
+func loadPromptTemplate(provider, model string) string {
+	file := promptTemplateFile(provider, model)
+	if file == "" {
+		return ""
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Printf("Failed to read prompt template %s, using the default prompt: %v", file, err)
+		return ""
+	}
+	template := string(data)
+	if !strings.Contains(template, placeholderInstructions) || !strings.Contains(template, placeholderFiles) {
+		log.Printf("Prompt template %s lacks %s or %s, using the default prompt", file, placeholderInstructions, placeholderFiles)
+		return ""
+	}
+	return template
+}
+
+// Fills in a template's placeholders. Everything is replaced in one pass,
+// so placeholder-like text inside the instructions or files stays as-is.
+func renderPromptTemplate(template, structure, permissions, languageRules, extraRules, history, instructions, filesJSON string) string {
+	return strings.NewReplacer(
+		placeholderStructure, structure,
+		placeholderPermissions, permissions,
+		placeholderLanguage, languageRules,
+		placeholderExtraRules, extraRules,
+		placeholderHistory, history,
+		placeholderInstructions, instructions,
+		placeholderFiles, filesJSON,
+	).Replace(template)
+}
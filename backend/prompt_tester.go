@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Body of POST /api/prompt/test
+type PromptTestRequest struct {
+	Prompt   string `json:"prompt"`
+	Provider string `json:"provider"`
+	Model    string `json:"model"`
+}
+
+// Sends a raw prompt to a provider and returns the raw output, without
+// gathering context, cleaning, parsing or applying anything. Only enabled
+// with ENABLE_PROMPT_TEST=true because it forwards arbitrary prompts.
+func handlePromptTest(w http.ResponseWriter, r *http.Request) {
+	if !envBool("ENABLE_PROMPT_TEST", false) {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "Prompt testing is disabled; set ENABLE_PROMPT_TEST=true to enable it", nil)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	var body PromptTestRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	if strings.TrimSpace(body.Prompt) == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "prompt is required", nil)
+		return
+	}
+
+	req := EditRequest{Provider: body.Provider, Model: resolveModelAlias(body.Model)}
+	started := time.Now()
+	output, usage, err := callProvider(r.Context(), req, body.Prompt)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":     "success",
+		"provider":   req.Provider,
+		"model":      req.Model,
+		"output":     output,
+		"elapsed_ms": time.Since(started).Milliseconds(),
+	}
+	if usage != nil {
+		response["usage"] = usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
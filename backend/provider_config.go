@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Largest provider response read into memory unless MAX_RESPONSE_BYTES says otherwise
+const defaultMaxResponseBytes = 32 << 20
+
+// Per-provider request settings, read from <PREFIX>_TIMEOUT,
+// <PREFIX>_STREAM_TIMEOUT, <PREFIX>_STREAM_IDLE_TIMEOUT, <PREFIX>_MAX_RETRIES,
+// <PREFIX>_MAX_RESPONSE_BYTES and <PREFIX>_HEADERS where PREFIX is e.g.
+// OPENROUTER
+type ProviderConfig struct {
+	Timeout    time.Duration     // per non-streaming attempt; 0 means no deadline
+	MaxRetries int               // extra attempts after a connection error, 429 or 5xx
+	Headers    map[string]string // sent with every request to the provider
+	// Streamed responses get a generous overall cap instead of Timeout, and
+	// fail early when no chunk arrives for StreamIdleTimeout
+	StreamTimeout     time.Duration
+	StreamIdleTimeout time.Duration
+	// Largest response body read, streamed or not; 0 means no limit
+	MaxResponseBytes int64
+}
+
+// Reads the settings for a provider ("openrouter", "ollama", "azure", "deepseek",
+// "grok").
+// Chat completion providers default to PROVIDER_TIMEOUT (5m); Ollama has no
+// overall deadline by default because loading a model can take minutes.
+// Streaming defaults to STREAM_TIMEOUT (30m) overall and STREAM_IDLE_TIMEOUT
+// (60s) between chunks.
+func providerConfig(provider string) ProviderConfig {
+	prefix := strings.ToUpper(provider)
+
+	defaultTimeout := envDuration("PROVIDER_TIMEOUT", 5*time.Minute)
+	if provider == "ollama" {
+		defaultTimeout = 0
+	}
+
+	cfg := ProviderConfig{
+		Timeout:    envDuration(prefix+"_TIMEOUT", defaultTimeout),
+		MaxRetries: envInt(prefix+"_MAX_RETRIES", 0),
+		Headers:    map[string]string{},
+
+		StreamTimeout:     envDuration(prefix+"_STREAM_TIMEOUT", envDuration("STREAM_TIMEOUT", 30*time.Minute)),
+		StreamIdleTimeout: envDuration(prefix+"_STREAM_IDLE_TIMEOUT", envDuration("STREAM_IDLE_TIMEOUT", 60*time.Second)),
+
+		MaxResponseBytes: int64(envInt(prefix+"_MAX_RESPONSE_BYTES", envInt("MAX_RESPONSE_BYTES", defaultMaxResponseBytes))),
+	}
+
+	// Comma-separated "Name: value" pairs, e.g. "HTTP-Referer: http://localhost, X-Title: AI Builder"
+	for _, entry := range envList(prefix + "_HEADERS") {
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			log.Printf("Ignoring %s_HEADERS entry %q (want Name: value)", prefix, entry)
+			continue
+		}
+		cfg.Headers[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return cfg
+}
+
+// Reports whether a failed attempt is worth repeating: the request never got
+// a response, or the provider was rate limited or had a server error
+func retryableStatus(resp *http.Response, err error) bool {
+	if errors.Is(err, errResponseTooLarge) {
+		return false // it would be just as large again
+	}
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// Returned when a provider response is over cfg.MaxResponseBytes
+var errResponseTooLarge = errors.New("response too large")
+
+func responseTooLargeError(limit int64) error {
+	return fmt.Errorf("%w: the provider sent more than the %d byte limit (MAX_RESPONSE_BYTES); the request was aborted", errResponseTooLarge, limit)
+}
+
+// Reads a response body, failing with errResponseTooLarge instead of
+// buffering more than cfg.MaxResponseBytes
+func readResponseBody(cfg ProviderConfig, body io.Reader) ([]byte, error) {
+	if cfg.MaxResponseBytes <= 0 {
+		return ioutil.ReadAll(body)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, cfg.MaxResponseBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > cfg.MaxResponseBytes {
+		return nil, responseTooLargeError(cfg.MaxResponseBytes)
+	}
+	return data, nil
+}
+
+// Delay before retry number attempt (1-based): 1s, 2s, 4s, ... capped at 30s
+func retryBackoff(attempt int) time.Duration {
+	delay := time.Second << uint(attempt-1)
+	if delay > 30*time.Second || delay <= 0 {
+		delay = 30 * time.Second
+	}
+	return delay
+}
+
+// Waits for d, returning ctx's error early if it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strings"
+)
+
+// Settings each provider can't work without. Ollama runs locally and needs none.
+var providerRequiredEnv = map[string][]string{
+	"openrouter": {"OPENROUTER_API_KEY"},
+	"azure":      {"AZURE_OPENAI_KEY", "AZURE_OPENAI_ENDPOINT"},
+	"deepseek":   {"DEEPSEEK_API_KEY"},
+	"grok":       {"XAI_API_KEY"},
+}
+
+// The required settings of a provider that are unset, nil when it can be used
+func missingProviderEnv(provider string) []string {
+	var missing []string
+	for _, key := range providerRequiredEnv[provider] {
+		if getenvTrimmed(key) == "" {
+			missing = append(missing, key)
+		}
+	}
+	return missing
+}
+
+// Logs which providers are configured and which miss settings, so a
+// missing key shows up at startup rather than on the first failed edit
+func logProviderAvailability() {
+	providers := make([]string, 0, len(defaultModels))
+	for provider := range defaultModels {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+
+	var available []string
+	for _, provider := range providers {
+		if missing := missingProviderEnv(provider); len(missing) > 0 {
+			log.Printf("Provider %s unavailable: %s not set", provider, strings.Join(missing, ", "))
+			continue
+		}
+		available = append(available, provider)
+	}
+	if len(available) == 0 {
+		log.Printf("Warning: no provider is configured")
+		return
+	}
+	log.Printf("Providers available: %s", strings.Join(available, ", "))
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Per-client token buckets guarding the endpoints that call paid provider APIs
+type rateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+var editLimiter = &rateLimiter{buckets: map[string]*tokenBucket{}}
+
+// Takes a token from the client's bucket. Buckets hold up to perMinute tokens
+// and refill continuously. When empty it reports how long until the next token.
+func (l *rateLimiter) allow(client string, perMinute int) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	capacity := float64(perMinute)
+	refillPerSecond := capacity / 60
+
+	// A bucket idle for a minute is full again, so it can be forgotten
+	if now.Sub(l.lastSweep) > time.Minute {
+		for key, bucket := range l.buckets {
+			if now.Sub(bucket.last) > time.Minute {
+				delete(l.buckets, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.buckets[client]
+	if !ok {
+		bucket = &tokenBucket{tokens: capacity, last: now}
+		l.buckets[client] = bucket
+	}
+
+	bucket.tokens = math.Min(capacity, bucket.tokens+now.Sub(bucket.last).Seconds()*refillPerSecond)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - bucket.tokens) / refillPerSecond * float64(time.Second))
+	return false, wait
+}
+
+// Rejects requests with 429 once a client exceeds RATE_LIMIT_PER_MINUTE.
+// Limiting is off when the setting is unset or 0.
+func withRateLimit(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		perMinute := envInt("RATE_LIMIT_PER_MINUTE", 0)
+		if perMinute <= 0 {
+			handler(w, r)
+			return
+		}
+
+		client := clientIP(r)
+		if ok, wait := editLimiter.allow(client, perMinute); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			log.Printf("Rate limit exceeded for %s, retry in %ds", client, retryAfter)
+			w.Header().Set("Retry-After", fmt.Sprint(retryAfter))
+			writeError(w, http.StatusTooManyRequests, errCodeRateLimited, "Rate limit exceeded, try again later", map[string]int{"retry_after_seconds": retryAfter})
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Returns the IP address of the client that sent the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
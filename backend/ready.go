@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long a readiness result is reused, so frequent probes don't each
+// hit Ollama
+const readinessCacheTTL = 5 * time.Second
+
+// Outcome of the last readiness check, as returned by /api/ready
+type Readiness struct {
+	Ready     bool      `json:"ready"`
+	Model     string    `json:"model,omitempty"`
+	Status    string    `json:"status"` // "ready", "pulling", "not_pulled" or "unavailable"
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type readinessState struct {
+	mu      sync.Mutex
+	last    *Readiness
+	pulling bool
+	pullErr string
+}
+
+var readiness = &readinessState{}
+
+// Reports 200 once the OLLAMA_MODEL is pulled and answers a load request,
+// 503 until then, so an orchestrator can hold traffic back until edits can
+// actually work. Always ready when OLLAMA_MODEL is unset.
+func handleReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, "GET")
+		return
+	}
+
+	state := readiness.check(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !state.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(state)
+}
+
+// Checks the configured model, reusing a recent result
+func (s *readinessState) check(ctx context.Context) Readiness {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	model := resolveModelAlias(getenvTrimmed("OLLAMA_MODEL"))
+	if model == "" {
+		return Readiness{Ready: true, Status: "ready", CheckedAt: time.Now()}
+	}
+	if s.pulling {
+		return Readiness{Model: model, Status: "pulling", CheckedAt: time.Now()}
+	}
+	if s.last != nil && s.last.Model == model && time.Since(s.last.CheckedAt) < readinessCacheTTL {
+		return *s.last
+	}
+
+	state := Readiness{Model: model, CheckedAt: time.Now()}
+	pulled, err := fetchOllamaModels()
+	switch {
+	case err != nil:
+		state.Status, state.Error = "unavailable", err.Error()
+	case !containsModel(pulled, model):
+		state.Status = "not_pulled"
+		state.Error = s.pullErr
+		if state.Error == "" {
+			state.Error = fmt.Sprintf("model %s is not pulled; run ollama pull %s or set OLLAMA_PULL_ON_START=true", model, model)
+		}
+	default:
+		if err := warmOllamaModel(model, envDuration("READY_CHECK_TIMEOUT", 10*time.Second)); err != nil {
+			state.Status, state.Error = "unavailable", err.Error()
+		} else {
+			state.Ready, state.Status = true, "ready"
+		}
+	}
+	s.last = &state
+	return state
+}
+
+// Pulls OLLAMA_MODEL in the background at startup when OLLAMA_PULL_ON_START
+// is set and the model isn't there yet, then loads it. /api/ready reports
+// "pulling" meanwhile.
+func pullOllamaModelOnStart() {
+	model := resolveModelAlias(getenvTrimmed("OLLAMA_MODEL"))
+	if model == "" || !envBool("OLLAMA_PULL_ON_START", false) {
+		return
+	}
+	if pulled, err := fetchOllamaModels(); err == nil && containsModel(pulled, model) {
+		return
+	}
+
+	readiness.mu.Lock()
+	readiness.pulling = true
+	readiness.mu.Unlock()
+
+	go func() {
+		log.Printf("Pulling Ollama model %s", model)
+		err := pullOllamaModel(model, envDuration("OLLAMA_PULL_TIMEOUT", time.Hour))
+		if err == nil {
+			log.Printf("Pulled Ollama model %s", model)
+			err = warmOllamaModel(model, envDuration("WARMUP_TIMEOUT", 2*time.Minute))
+		} else {
+			log.Printf("Failed to pull Ollama model %s: %v", model, err)
+		}
+
+		readiness.mu.Lock()
+		defer readiness.mu.Unlock()
+		readiness.pulling = false
+		readiness.last = nil
+		readiness.pullErr = ""
+		if err != nil {
+			readiness.pullErr = err.Error()
+		}
+	}()
+}
+
+// Asks Ollama to download a model and waits until it has
+func pullOllamaModel(model string, timeout time.Duration) error {
+	jsonData, err := json.Marshal(map[string]interface{}{"model": model, "stream": false})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:11434/api/pull", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Ollama (make sure it's running on localhost:11434): %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Ollama API error %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// Reports whether Ollama's model list has the model. Names without a tag
+// mean ":latest", as in the ollama CLI.
+func containsModel(models []string, model string) bool {
+	if !strings.Contains(model, ":") {
+		model += ":latest"
+	}
+	for _, name := range models {
+		if !strings.Contains(name, ":") {
+			name += ":latest"
+		}
+		if name == model {
+			return true
+		}
+	}
+	return false
+}
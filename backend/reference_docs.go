@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Most reference doc text added to a prompt unless REFERENCE_DOCS_MAX_BYTES
+// says otherwise
+const defaultReferenceDocsMaxBytes = 64 << 10
+
+// Project-level docs ARCHITECTURE_CONTEXT adds from frontend/ when they exist
+var architectureDocNames = []string{"README.md", "ARCHITECTURE.md"}
+
+// Document paths from REFERENCE_DOCS, e.g. "DESIGN.md,docs/API.md",
+// preceded by the README and ARCHITECTURE.md of the project when
+// ARCHITECTURE_CONTEXT is on. Relative paths are resolved against
+// frontend/, like ALWAYS_INCLUDE.
+func referenceDocPaths() []string {
+	configured := envList("REFERENCE_DOCS")
+	if !envBool("ARCHITECTURE_CONTEXT", false) {
+		return configured
+	}
+
+	var paths []string
+	for _, name := range architectureDocNames {
+		if _, err := os.Stat(referenceDocFile(name)); err != nil {
+			continue
+		}
+		listed := false
+		for _, path := range configured {
+			if filepath.Clean(path) == name {
+				listed = true
+			}
+		}
+		if !listed {
+			paths = append(paths, name)
+		}
+	}
+	return append(paths, configured...)
+}
+
+func referenceDocFile(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(projectRoot), path)
+}
+
+// Reports whether a normalized action path targets one of the reference docs
+func isReferenceDoc(normalizedPath string) bool {
+	for _, path := range referenceDocPaths() {
+		if normalizePath(filepath.ToSlash(path)) == normalizedPath {
+			return true
+		}
+	}
+	return false
+}
+
+// The REFERENCE DOCS prompt section with the contents of REFERENCE_DOCS.
+// These are conventions to follow, not files to edit, so they are kept out
+// of the project files JSON. Missing docs are logged and skipped; the total
+// is capped at REFERENCE_DOCS_MAX_BYTES.
+func referenceDocsSection() string {
+	paths := referenceDocPaths()
+	if len(paths) == 0 {
+		return ""
+	}
+	budget := envInt("REFERENCE_DOCS_MAX_BYTES", defaultReferenceDocsMaxBytes)
+
+	var b strings.Builder
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(referenceDocFile(path))
+		if err != nil {
+			log.Printf("Skipping reference doc %s: %v", path, err)
+			continue
+		}
+		content := strings.TrimSpace(string(data))
+		if budget > 0 {
+			remaining := budget - b.Len()
+			if remaining <= 0 {
+				log.Printf("Skipping reference doc %s: REFERENCE_DOCS_MAX_BYTES reached", path)
+				continue
+			}
+			if len(content) > remaining {
+				log.Printf("Reference doc %s truncated to fit REFERENCE_DOCS_MAX_BYTES", path)
+				content = strings.ToValidUTF8(content[:remaining], "") + "\n[truncated]"
+			}
+		}
+		fmt.Fprintf(&b, "\n--- %s ---\n%s\n", filepath.ToSlash(path), content)
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "\nREFERENCE DOCS (project intent and conventions to follow; read-only, NEVER create, update or delete these):\n" + b.String()
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Replaces lines startLine..endLine (1-based, inclusive) of existing with
+// content. Empty content deletes the lines. The file's trailing newline, if
+// any, is kept.
+func replaceLineRange(existing string, startLine, endLine int, content string) (string, error) {
+	trailingNewline := strings.HasSuffix(existing, "\n")
+	lines := strings.Split(strings.TrimSuffix(existing, "\n"), "\n")
+	if existing == "" {
+		lines = nil
+	}
+
+	if startLine < 1 || endLine < startLine {
+		return "", fmt.Errorf("invalid line range %d-%d", startLine, endLine)
+	}
+	if endLine > len(lines) {
+		return "", fmt.Errorf("line range %d-%d is outside the file (%d lines)", startLine, endLine, len(lines))
+	}
+
+	var replacement []string
+	if content != "" {
+		replacement = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+
+	merged := make([]string, 0, len(lines)-(endLine-startLine+1)+len(replacement))
+	merged = append(merged, lines[:startLine-1]...)
+	merged = append(merged, replacement...)
+	merged = append(merged, lines[endLine:]...)
+
+	result := strings.Join(merged, "\n")
+	if trailingNewline && len(merged) > 0 {
+		result += "\n"
+	}
+	return result, nil
+}
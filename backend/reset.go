@@ -0,0 +1,202 @@
+package main
+
+import (
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The files a reset writes to src/, e.g. scaffold/App.tsx becomes src/App.tsx
+//
+//go:embed scaffold
+var scaffoldFiles embed.FS
+
+// How long a reset confirmation token stays valid
+const resetTokenTTL = 5 * time.Minute
+
+// Confirmation tokens handed out by /api/reset, with their expiry
+var resetTokens = struct {
+	sync.Mutex
+	expires map[string]time.Time
+}{expires: map[string]time.Time{}}
+
+// Deletes the context-eligible files under src/ and writes the embedded
+// scaffold in their place. Without a token, nothing is touched: the
+// response lists what would be deleted and carries a confirm_token, valid
+// for a few minutes and once, to send back as {"confirm_token": "..."}.
+// SidePanel.tsx and files of other types, such as images, are kept.
+// POST /api/reset
+func handleReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	var body struct {
+		ConfirmToken string `json:"confirm_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+
+	paths, err := resettableFiles()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if body.ConfirmToken == "" {
+		token, err := newResetToken()
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":        "confirmation_required",
+			"confirm_token": token,
+			"expires_in":    int(resetTokenTTL.Seconds()),
+			"would_delete":  paths,
+		})
+		return
+	}
+	if !useResetToken(body.ConfirmToken) {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "Unknown or expired confirm_token; request a new one by posting without it", nil)
+		return
+	}
+
+	for _, p := range paths {
+		if err := os.Remove(projectFilePath(p)); err != nil && !os.IsNotExist(err) {
+			writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), nil)
+			return
+		}
+	}
+	removeEmptyDirs(projectRoot)
+
+	created, err := writeScaffold()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, errCodeInternal, err.Error(), map[string]interface{}{"deleted": paths, "created": created})
+		return
+	}
+	log.Printf("Reset the project: deleted %d files, created %d", len(paths), len(created))
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"deleted": paths,
+		"created": created,
+	})
+}
+
+// The "src/..." paths a reset deletes: context-eligible files, except the
+// protected side panel. WalkDir doesn't follow symlinks, so nothing outside
+// src/ is reached.
+func resettableFiles() ([]string, error) {
+	paths := []string{}
+	types := contextExtensions()
+	err := filepath.WalkDir(projectRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if sandboxSkipDirs[d.Name()] && p != projectRoot {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || strings.Contains(p, "SidePanel") || !matchesFileType(p, types) {
+			return nil
+		}
+		rel, err := filepath.Rel(projectRoot, p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, "src/"+filepath.ToSlash(rel))
+		return nil
+	})
+	return paths, err
+}
+
+// Removes the directories under root left empty. Deepest first, so
+// children are removed before their parents.
+func removeEmptyDirs(root string) {
+	var dirs []string
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() && p != root {
+			dirs = append(dirs, p)
+		}
+		return nil
+	})
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 0 {
+			os.Remove(dir)
+		}
+	}
+}
+
+// Writes the embedded scaffold into src/ and returns the "src/..." paths
+func writeScaffold() ([]string, error) {
+	created := []string{}
+	err := fs.WalkDir(scaffoldFiles, "scaffold", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := scaffoldFiles.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		normalized := "src/" + strings.TrimPrefix(p, "scaffold/")
+		fullPath := projectFilePath(normalized)
+		if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(fullPath, data, 0644); err != nil {
+			return err
+		}
+		created = append(created, normalized)
+		return nil
+	})
+	return created, err
+}
+
+func newResetToken() (string, error) {
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	resetTokens.Lock()
+	defer resetTokens.Unlock()
+	for old, expires := range resetTokens.expires {
+		if time.Now().After(expires) {
+			delete(resetTokens.expires, old)
+		}
+	}
+	resetTokens.expires[token] = time.Now().Add(resetTokenTTL)
+	return token, nil
+}
+
+// Reports whether the token was handed out and hasn't expired, and
+// invalidates it
+func useResetToken(token string) bool {
+	resetTokens.Lock()
+	defer resetTokens.Unlock()
+	expires, ok := resetTokens.expires[token]
+	delete(resetTokens.expires, token)
+	return ok && time.Now().Before(expires)
+}
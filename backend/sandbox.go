@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an unpromoted sandbox is kept unless SANDBOX_TTL says otherwise
+const defaultSandboxTTL = 30 * time.Minute
+
+// Directories of the frontend that are not copied into a sandbox.
+// node_modules is linked instead, so the sandbox can still be built.
+var sandboxSkipDirs = map[string]bool{"node_modules": true, ".git": true, "dist": true}
+
+// A copy of the frontend with a set of edits applied, kept until it is
+// promoted to the real project, discarded or expires
+type sandbox struct {
+	id           string
+	dir          string // the copied frontend directory
+	actions      AIEditActions
+	baseHashes   map[string]string
+	baseRevision string
+	request      EditRequest
+	created      time.Time
+}
+
+type sandboxStore struct {
+	mu        sync.Mutex
+	sandboxes map[string]*sandbox
+}
+
+var sandboxes = &sandboxStore{sandboxes: map[string]*sandbox{}}
+
+// Generates edits like /api/edit, but applies them to a temporary copy of
+// the frontend and optionally builds it there ("build": true). Returns the
+// per-file diffs against the real project and the build outcome; the real
+// project is never touched. Promote the result with /api/sandbox/promote.
+func handleSandbox(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	var body struct {
+		EditRequest
+		Build bool `json:"build"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return
+	}
+	req := body.EditRequest
+	if req.SessionID == "" {
+		req.SessionID = sessionKey(r)
+	}
+
+	gen, err := generateEdits(r.Context(), req)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+
+	box, err := newSandbox()
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	box.actions, box.baseHashes, box.baseRevision, box.request = gen.Edits, gen.BaseHashes, gen.BaseRevision, req
+
+	opts := applyOptionsFor(req)
+	opts.BaseHashes = gen.BaseHashes
+	opts.Root = filepath.Join(box.dir, filepath.Base(projectRoot))
+	result, err := applyEdits(r.Context(), gen.Edits, opts)
+	if err != nil {
+		box.remove()
+		writeAPIError(w, err)
+		return
+	}
+	sandboxes.add(box)
+
+	files := make([]PreviewFile, 0, len(result.Changed))
+	for _, path := range result.Changed {
+		before, err := ioutil.ReadFile(projectFilePath(path))
+		existed := err == nil
+		after, err := ioutil.ReadFile(opts.filePath(path))
+		change := "update"
+		switch {
+		case err != nil:
+			change = "delete"
+		case !existed:
+			change = "create"
+		}
+		files = append(files, PreviewFile{Type: change, Path: path, Diff: unifiedDiff(path, string(before), string(after), existed)})
+	}
+
+	response := map[string]interface{}{
+		"status":     "success",
+		"sandbox_id": box.id,
+		"actions":    gen.Edits.Actions,
+		"applied":    result.Applied,
+		"files":      files,
+		"provider":   gen.Provider,
+		"model":      gen.Model,
+	}
+	addModelMessage(response, gen.Edits)
+	if len(result.Skipped) > 0 {
+		response["skipped"] = result.Skipped
+	}
+	if body.Build {
+		response["build"] = box.build(r.Context())
+	}
+	if gen.Usage != nil {
+		response["usage"] = gen.Usage
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Applies a sandbox's edits to the real project, like /api/apply with the
+// base hashes from when it was created, so files changed in the meantime
+// are reported as conflicts. Takes {"sandbox_id"}.
+func handleSandboxPromote(w http.ResponseWriter, r *http.Request) {
+	box, ok := sandboxFromBody(w, r)
+	if !ok {
+		return
+	}
+	defer box.remove()
+
+	opts := applyOptionsFor(box.request)
+	opts.BaseHashes = box.baseHashes
+	opts.BaseRevision = box.baseRevision
+	result, err := applyEdits(r.Context(), box.actions, opts)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeAuditManifest(r.URL.Path, box.request, box.actions.Actions, result)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildApplyResponse(result, opts))
+}
+
+// Deletes a sandbox without applying it. Takes {"sandbox_id"}.
+func handleSandboxDiscard(w http.ResponseWriter, r *http.Request) {
+	box, ok := sandboxFromBody(w, r)
+	if !ok {
+		return
+	}
+	box.remove()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// Takes the sandbox named in a POST body out of the store, writing the
+// error response when that fails
+func sandboxFromBody(w http.ResponseWriter, r *http.Request) (*sandbox, bool) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return nil, false
+	}
+	var body struct {
+		ID string `json:"sandbox_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, err.Error(), nil)
+		return nil, false
+	}
+	box := sandboxes.take(body.ID)
+	if box == nil {
+		writeError(w, http.StatusNotFound, errCodeNotFound, "No sandbox with this id; it may have expired", nil)
+		return nil, false
+	}
+	return box, true
+}
+
+// Copies the frontend into a new temporary directory
+func newSandbox() (*sandbox, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "ai-sidepanel-sandbox-")
+	if err != nil {
+		return nil, err
+	}
+	box := &sandbox{id: hex.EncodeToString(idBytes), dir: dir, created: time.Now()}
+
+	if err := copyFrontend(filepath.Dir(projectRoot), dir); err != nil {
+		box.remove()
+		return nil, err
+	}
+	return box, nil
+}
+
+// Copies the regular files under src to dst, skipping sandboxSkipDirs at
+// the top level and linking node_modules
+func copyFrontend(src, dst string) error {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if sandboxSkipDirs[rel] {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, filepath.Join(dst, rel))
+	})
+	if err != nil {
+		return err
+	}
+
+	modules, err := filepath.Abs(filepath.Join(src, "node_modules"))
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(modules); err == nil {
+		return os.Symlink(modules, filepath.Join(dst, "node_modules"))
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Runs SANDBOX_BUILD_COMMAND (e.g. "npx tsc --noEmit" or "npm run build")
+// in the sandbox. The command is split on whitespace and run without a
+// shell. Returns nil when no command is configured.
+func (box *sandbox) build(ctx context.Context) *HookResult {
+	command := strings.TrimSpace(os.Getenv("SANDBOX_BUILD_COMMAND"))
+	if command == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, envDuration("SANDBOX_BUILD_TIMEOUT", 2*time.Minute))
+	defer cancel()
+
+	fields := strings.Fields(command)
+	cmd := exec.CommandContext(ctx, fields[0], fields[1:]...)
+	cmd.Dir = box.dir
+
+	output, err := cmd.CombinedOutput()
+	result := &HookResult{Command: command, Output: string(output)}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.ExitCode = -1
+		}
+		result.Error = err.Error()
+		log.Printf("Sandbox %s build failed: %v", box.id, err)
+	}
+	return result
+}
+
+func (box *sandbox) remove() {
+	if err := os.RemoveAll(box.dir); err != nil {
+		log.Printf("Failed to remove sandbox %s: %v", box.dir, err)
+	}
+}
+
+// Stores a sandbox, removing the ones that outlived SANDBOX_TTL
+func (s *sandboxStore) add(box *sandbox) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ttl := envDuration("SANDBOX_TTL", defaultSandboxTTL)
+	for id, old := range s.sandboxes {
+		if time.Since(old.created) > ttl {
+			old.remove()
+			delete(s.sandboxes, id)
+		}
+	}
+	s.sandboxes[box.id] = box
+}
+
+// Removes a sandbox from the store and returns it, or nil
+func (s *sandboxStore) take(id string) *sandbox {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	box := s.sandboxes[id]
+	delete(s.sandboxes, id)
+	if box != nil && time.Since(box.created) > envDuration("SANDBOX_TTL", defaultSandboxTTL) {
+		box.remove()
+		return nil
+	}
+	return box
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A directory outside src/ whose files are sent to the model too, e.g. the
+// shared code of a monorepo. Its files appear in the context and in action
+// paths as "<name>/...", where name is the directory's base name.
+type scanDir struct {
+	Name     string
+	Dir      string
+	Writable bool
+}
+
+// Directories from EXTRA_SCAN_DIRS, e.g. "../shared,../design:rw". Relative
+// paths are resolved against frontend/, like ALWAYS_INCLUDE. Directories
+// are read-only unless marked ":rw". Entries whose name clashes with src/
+// or an earlier entry are logged and ignored.
+func scanDirs() []scanDir {
+	var dirs []scanDir
+	seen := map[string]bool{"src": true}
+	for _, entry := range envList("EXTRA_SCAN_DIRS") {
+		dir, writable := strings.CutSuffix(entry, ":rw")
+		dir = strings.TrimSuffix(dir, ":ro")
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(projectRoot), dir)
+		}
+		dir = filepath.Clean(dir)
+		name := filepath.Base(dir)
+		if name == "." || name == ".." || name == string(filepath.Separator) || seen[name] {
+			log.Printf("Ignoring EXTRA_SCAN_DIRS entry %q: its name %q is taken or unusable", entry, name)
+			continue
+		}
+		seen[name] = true
+		dirs = append(dirs, scanDir{Name: name, Dir: dir, Writable: writable})
+	}
+	return dirs
+}
+
+// The scan directory a normalized path points into and the path inside it
+func scanDirFor(normalizedPath string) (scanDir, string, bool) {
+	for _, dir := range scanDirs() {
+		if rel, ok := strings.CutPrefix(normalizedPath, dir.Name+"/"); ok && rel != "" {
+			return dir, rel, true
+		}
+	}
+	return scanDir{}, "", false
+}
+
+// The path a context file is known by in hashes and action paths: "src/..."
+// for project files, "<name>/..." for files from a scan directory
+func contextFileKey(file FileJSON) string {
+	path := filepath.ToSlash(file.Path)
+	if _, _, ok := scanDirFor(path); ok {
+		return path
+	}
+	return "src/" + path
+}
+
+// Reads the context-eligible files of the scan directories, skipping the
+// directories a sandbox doesn't copy either. Missing directories are logged.
+func gatherScanDirFiles() ([]FileJSON, error) {
+	var files []FileJSON
+	for _, dir := range scanDirs() {
+		if _, err := os.Stat(dir.Dir); err != nil {
+			log.Printf("Skipping scan directory %s: %v", dir.Dir, err)
+			continue
+		}
+		err := filepath.WalkDir(dir.Dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if sandboxSkipDirs[d.Name()] && path != dir.Dir {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !matchesFileType(path, contextExtensions()) {
+				return nil
+			}
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir.Dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, FileJSON{
+				Path:     dir.Name + "/" + filepath.ToSlash(rel),
+				Content:  string(b),
+				Hash:     contentHash(b),
+				ReadOnly: !dir.Writable,
+			})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// Why an action may not touch a path in a scan directory, or "" when it may
+// (or the path isn't in one). Only ":rw" directories are writable, never
+// from a sandbox or an edit branch's worktree since they aren't part of it,
+// and a path must stay inside its directory even through symlinks.
+func scanDirRejection(normalizedPath string, opts ApplyOptions) string {
+	dir, rel, ok := scanDirFor(normalizedPath)
+	if !ok {
+		return ""
+	}
+	if !dir.Writable {
+		return fmt.Sprintf("%s is a read-only scan directory", dir.Name)
+	}
+	if opts.Root != "" {
+		return "scan directories outside src/ can't be changed in a sandbox or on an edit branch"
+	}
+
+	root, err := filepath.EvalSymlinks(dir.Dir)
+	if err != nil {
+		return fmt.Sprintf("scan directory %s is not readable: %v", dir.Name, err)
+	}
+	// The deepest part of the path that exists decides where it really is
+	target := filepath.Join(root, filepath.FromSlash(rel))
+	existing := target
+	for {
+		if resolved, err := filepath.EvalSymlinks(existing); err == nil {
+			target = filepath.Join(resolved, strings.TrimPrefix(target, existing))
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	inside, err := filepath.Rel(root, target)
+	if err != nil || inside == ".." || strings.HasPrefix(inside, ".."+string(filepath.Separator)) {
+		return "path is outside the scan directory"
+	}
+	return ""
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A file applied while the model was still generating
+type StreamProgress struct {
+	Type      string `json:"type"`
+	Path      string `json:"path"`
+	ElapsedMs int64  `json:"elapsed_ms"`
+}
+
+// Pulls complete action objects out of a partially received model response.
+// It tracks brace depth and string state inside the "actions" array, so an
+// action can be decoded as soon as its closing brace arrives.
+type actionStreamParser struct {
+	pos      int
+	inArray  bool
+	done     bool
+	failed   bool
+	depth    int
+	inString bool
+	escaped  bool
+	objStart int
+}
+
+// Scans the newly received part of text and returns the actions completed in it
+func (p *actionStreamParser) feed(text string) []EditAction {
+	if p.done || p.failed {
+		return nil
+	}
+
+	if !p.inArray {
+		key := strings.Index(text, `"actions"`)
+		if key < 0 {
+			return nil
+		}
+		bracket := strings.IndexByte(text[key:], '[')
+		if bracket < 0 {
+			return nil
+		}
+		p.pos = key + bracket + 1
+		p.inArray = true
+	}
+
+	var actions []EditAction
+	for ; p.pos < len(text); p.pos++ {
+		c := text[p.pos]
+		if p.inString {
+			switch {
+			case p.escaped:
+				p.escaped = false
+			case c == '\\':
+				p.escaped = true
+			case c == '"':
+				p.inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			p.inString = true
+		case '{':
+			if p.depth == 0 {
+				p.objStart = p.pos
+			}
+			p.depth++
+		case '}':
+			p.depth--
+			if p.depth == 0 {
+				var act EditAction
+				if err := json.Unmarshal([]byte(text[p.objStart:p.pos+1]), &act); err != nil {
+					log.Printf("Streaming parse failed, will fall back to batch parse: %v", err)
+					p.failed = true
+					return actions
+				}
+				actions = append(actions, act)
+			}
+		case ']':
+			if p.depth == 0 {
+				p.done = true
+				return actions
+			}
+		}
+	}
+	return actions
+}
+
+// Outcome of a streamed edit
+type streamOutcome struct {
+	Result   *ApplyResult
+	Actions  []EditAction // every action handled, applied or skipped
+	Progress []StreamProgress
+	Usage    *TokenUsage
+	// Content hashes of the writable files sent to the model
+	BaseHashes map[string]string
+	// The full reply once parsed, for its model_message
+	Edits AIEditActions
+	// Where the actions were applied
+	Options ApplyOptions
+}
+
+// Streams the model output from Ollama and applies each action as soon as it
+// is complete. If the incremental parse fails, the remaining actions are taken
+// from a batch parse of the full response once the stream ends.
+func streamEdits(ctx context.Context, req EditRequest) (*streamOutcome, error) {
+	req.Model = resolveModelAlias(req.Model)
+
+	prompt, err := buildEditPrompt(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := applyOptionsFor(req)
+	opts.BaseHashes = prompt.BaseHashes
+	opts.BaseRevision = prompt.BaseRevision
+	editBranchFrom(ctx).applyTo(&opts)
+	outcome := &streamOutcome{Result: &ApplyResult{}, BaseHashes: prompt.BaseHashes, Options: opts}
+	applier := newStreamApplier(ctx, opts, outcome)
+
+	release, err := acquireProviderSlot(ctx, req.Provider)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	parser := &actionStreamParser{}
+	callCtx, callSpan := tracer.Start(ctx, "provider.call", trace.WithAttributes(requestAttributes(req)...))
+	aiResponse, usage, err := generateWithOllama(callCtx, prompt.Text, req.Model, true, func(text string) {
+		applier.apply(parser.feed(text))
+	})
+	callSpan.SetAttributes(attribute.Int("edit.response_bytes", len(aiResponse)))
+	recordSpanError(callSpan, err)
+	callSpan.End()
+	if err != nil {
+		if applyErr := applier.finish(); applyErr != nil {
+			return outcome, applyErr
+		}
+		return outcome, newAPIError(http.StatusBadGateway, errCodeUpstream, err.Error(), map[string]interface{}{
+			"provider":        req.Provider,
+			"model":           req.Model,
+			"handled_actions": len(outcome.Actions),
+			"partial_result":  outcome.Result,
+		})
+	}
+
+	usage.estimateCost(req.Provider, req.Model)
+	outcome.Usage = usage
+
+	// The full reply goes through the same parse as a normal edit, for its
+	// model_message and, when the incremental parse fell short, the rest of
+	// the actions
+	gen, parseErr := parseGeneration(ctx, req, prompt, aiResponse, usage)
+	if parseErr == nil {
+		outcome.Edits = gen.Edits
+	}
+	if applier.err == nil && (!parser.done || parser.failed) {
+		handled := len(outcome.Actions)
+		log.Printf("Streaming parse incomplete after %d actions, falling back to batch parse", handled)
+		if parseErr != nil {
+			applier.finish()
+			return outcome, parseErr
+		}
+		if len(gen.Edits.Actions) > handled {
+			applier.apply(gen.Edits.Actions[handled:])
+		}
+	}
+	return outcome, applier.finish()
+}
+
+// Applies streamed actions one at a time as they complete while keeping the
+// checks applyEdits runs over a whole batch: a create of a file the stream
+// already created follows DUPLICATE_CREATE_MODE, the entry file is checked
+// once the stream is over, and with APPLY_CHUNK_SIZE set an action that
+// fails restores every file the stream wrote, as a chunked apply does.
+type streamApplier struct {
+	ctx     context.Context
+	opts    ApplyOptions
+	outcome *streamOutcome
+	started time.Time
+	// Index in outcome.Actions of the create that wrote each path, or -1
+	// once DUPLICATE_CREATE_MODE=reject dropped the path's creates
+	created     map[string]int
+	backups     map[string]fileBackup // full path -> state before the stream
+	entryBefore []byte
+	err         error // the first apply error; nothing is applied after it
+}
+
+func newStreamApplier(ctx context.Context, opts ApplyOptions, outcome *streamOutcome) *streamApplier {
+	return &streamApplier{
+		ctx:         ctx,
+		opts:        opts,
+		outcome:     outcome,
+		started:     time.Now(),
+		created:     map[string]int{},
+		backups:     map[string]fileBackup{},
+		entryBefore: readEntry(opts),
+	}
+}
+
+// Applies actions in order, skipping the duplicate creates
+func (s *streamApplier) apply(actions []EditAction) {
+	result := s.outcome.Result
+	for _, act := range actions {
+		if s.err != nil {
+			return
+		}
+		s.outcome.Actions = append(s.outcome.Actions, act)
+		path := actionPath(act.Path)
+		if reason := s.duplicateCreate(act); reason != "" {
+			log.Printf("Skipping %s %s: %s", act.Type, path, reason)
+			result.Skipped = append(result.Skipped, SkippedAction{Type: act.Type, Path: path, Reason: reason})
+			continue
+		}
+
+		single := AIEditActions{Actions: []EditAction{act}}
+		if s.opts.Root == "" {
+			if err := checkBaseRevision(s.ctx, s.opts.BaseRevision, single); err != nil {
+				s.err = err
+				return
+			}
+		}
+		s.backup(path)
+		progressFrom(s.ctx).setPhase(phaseApplying)
+		applyStarted := time.Now()
+		partial, err := applyEditActions(single, s.opts)
+		result.merge(partial)
+		result.ApplyMs += durationMs(time.Since(applyStarted))
+		if err != nil {
+			s.err = err
+			return
+		}
+		progressFrom(s.ctx).addApplied(partial.Changed)
+		if partial.Applied == 0 {
+			continue // skipped or unchanged, nothing was written
+		}
+		if act.Type == "create" {
+			s.created[path] = len(s.outcome.Actions) - 1
+		}
+
+		elapsed := time.Since(s.started).Milliseconds()
+		log.Printf("Streamed action %d applied after %dms: %s %s", len(s.outcome.Actions), elapsed, act.Type, act.Path)
+		s.outcome.Progress = append(s.outcome.Progress, StreamProgress{Type: act.Type, Path: path, ElapsedMs: elapsed})
+	}
+}
+
+// Why a create must be skipped because the stream already created the same
+// file, or "" when it may be applied. The reasons match duplicateCreates.
+// With DUPLICATE_CREATE_MODE=reject the earlier create is undone as well.
+func (s *streamApplier) duplicateCreate(act EditAction) string {
+	if act.Type != "create" {
+		return ""
+	}
+	path := actionPath(act.Path)
+	first, seen := s.created[path]
+	if !seen {
+		return ""
+	}
+	const rejected = "the batch creates this file more than once with different content (DUPLICATE_CREATE_MODE=reject)"
+	if first < 0 {
+		return rejected
+	}
+	if s.outcome.Actions[first].Content == act.Content {
+		return "repeats an identical create of the same file"
+	}
+
+	result := s.outcome.Result
+	if !containsString(result.DuplicateCreates, path) {
+		result.DuplicateCreates = append(result.DuplicateCreates, path)
+	}
+	switch strings.ToLower(os.Getenv("DUPLICATE_CREATE_MODE")) {
+	case "reject":
+		log.Printf("Warning: creates of %s with different content; rejecting all of them", path)
+		s.undoCreate(path, rejected)
+		return rejected
+	case "keep-first":
+		log.Printf("Warning: creates of %s with different content; keeping the first", path)
+		return "an earlier create of this file in the batch was kept (DUPLICATE_CREATE_MODE=keep-first)"
+	}
+	log.Printf("Warning: creates of %s with different content; keeping the last", path)
+	return ""
+}
+
+// Puts a file the stream created back as it was before the stream and
+// reports its create as skipped
+func (s *streamApplier) undoCreate(path, reason string) {
+	fullPath := s.opts.filePath(path)
+	restoreBackups(map[string]fileBackup{fullPath: s.backups[fullPath]})
+
+	result := s.outcome.Result
+	for i, changed := range result.Changed {
+		if changed == path {
+			result.Changed = append(result.Changed[:i], result.Changed[i+1:]...)
+			result.Applied--
+			break
+		}
+	}
+	result.Skipped = append(result.Skipped, SkippedAction{Type: "create", Path: path, Reason: reason})
+	s.created[path] = -1
+}
+
+// Remembers a file's state before the stream first touches it
+func (s *streamApplier) backup(path string) {
+	fullPath := s.opts.filePath(path)
+	if _, ok := s.backups[fullPath]; ok {
+		return
+	}
+	content, err := ioutil.ReadFile(fullPath)
+	switch {
+	case err == nil:
+		s.backups[fullPath] = fileBackup{content: content, existed: true}
+	case os.IsNotExist(err):
+		s.backups[fullPath] = fileBackup{}
+	}
+}
+
+// Runs the checks that need the whole stream and returns the apply error,
+// if any. With APPLY_CHUNK_SIZE set, a failed stream is rolled back.
+func (s *streamApplier) finish() error {
+	if s.err != nil {
+		if envInt("APPLY_CHUNK_SIZE", 0) <= 0 {
+			return s.err
+		}
+		restored := restoreBackups(s.backups)
+		log.Printf("Streamed apply failed, restored %d files", restored)
+		return fmt.Errorf("%w (%d files written by this stream were restored)", s.err, restored)
+	}
+	s.outcome.Result.EntryExport = checkEntryExport(s.outcome.Result, s.entryBefore, s.opts)
+	return nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds the outcome of another apply call to this result
+func (r *ApplyResult) merge(other *ApplyResult) {
+	if other == nil {
+		return
+	}
+	r.Applied += other.Applied
+	r.Changed = append(r.Changed, other.Changed...)
+	r.Skipped = append(r.Skipped, other.Skipped...)
+	r.Corrections = append(r.Corrections, other.Corrections...)
+	r.WriteMismatches = append(r.WriteMismatches, other.WriteMismatches...)
+	r.HeadersRestored = append(r.HeadersRestored, other.HeadersRestored...)
+	r.PathRewrites = append(r.PathRewrites, other.PathRewrites...)
+	r.DuplicateCreates = append(r.DuplicateCreates, other.DuplicateCreates...)
+	r.ElisionsMerged = append(r.ElisionsMerged, other.ElisionsMerged...)
+	if other.EntryExport != nil {
+		r.EntryExport = other.EntryExport
+	}
+	r.Conflicts = append(r.Conflicts, other.Conflicts...)
+	r.Unchanged = append(r.Unchanged, other.Unchanged...)
+	r.Timings = append(r.Timings, other.Timings...)
+	r.BytesWritten += other.BytesWritten
+	r.ApplyMs += other.ApplyMs
+	r.PendingOverwrites = append(r.PendingOverwrites, other.PendingOverwrites...)
+}
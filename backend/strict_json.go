@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The parse error for a strict_json reply that isn't exactly an actions
+// object, with the line and column where decoding stopped
+func strictJSONError(response string, err error) error {
+	details := map[string]string{"original_response": response}
+	message := fmt.Sprintf("AI response is not valid JSON (strict_json): %v", err)
+	if line, column, ok := jsonErrorPosition(response, err); ok {
+		message = fmt.Sprintf("AI response is not valid JSON (strict_json) at line %d, column %d: %v", line, column, err)
+		details["line"] = strconv.Itoa(line)
+		details["column"] = strconv.Itoa(column)
+	}
+	return newAPIError(http.StatusBadGateway, errCodeParseFailed, message, details)
+}
+
+// The 1-based line and column of the last byte a JSON decoding error read,
+// which is the offending character for syntax errors. ok is false for
+// errors without an offset.
+func jsonErrorPosition(text string, err error) (int, int, bool) {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return 0, 0, false
+	}
+	if offset > int64(len(text)) {
+		offset = int64(len(text))
+	}
+	if offset > 0 {
+		offset--
+	}
+
+	before := text[:offset]
+	line := strings.Count(before, "\n") + 1
+	column := len(before) - strings.LastIndex(before, "\n")
+	return line, column, true
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Longest user rule text added to a prompt; anything beyond is cut off
+const maxExtraRulesBytes = 8 << 10
+
+// Collects the user's prompt rules from SYSTEM_PROMPT_FILE and the request's
+// system_prompt, sanitized and capped at maxExtraRulesBytes
+func extraPromptRules(req EditRequest) string {
+	var parts []string
+	if path := os.Getenv("SYSTEM_PROMPT_FILE"); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Printf("Failed to read SYSTEM_PROMPT_FILE %s: %v", path, err)
+		} else if rules := sanitizeRules(string(data)); rules != "" {
+			parts = append(parts, rules)
+		}
+	}
+	if rules := sanitizeRules(req.SystemPrompt); rules != "" {
+		parts = append(parts, rules)
+	}
+
+	rules := strings.Join(parts, "\n")
+	if len(rules) > maxExtraRulesBytes {
+		log.Printf("Extra prompt rules truncated from %d to %d bytes", len(rules), maxExtraRulesBytes)
+		rules = strings.ToValidUTF8(rules[:maxExtraRulesBytes], "")
+	}
+	return rules
+}
+
+// Drops control characters (other than newlines and tabs) and blank lines
+func sanitizeRules(text string) string {
+	text = strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimRight(line, " \t"); strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Formats the user rules for buildPrompt, placed after the built-in rules
+// so they read as additions rather than replacements
+func extraRulesSection(rules string) string {
+	if rules == "" {
+		return ""
+	}
+	return "\nADDITIONAL PROJECT RULES (from the user; if any of these conflict with the rules above, the rules above win):\n" + rules + "\n"
+}
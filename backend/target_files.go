@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Normalized "src/..." paths of the files a request names in target_files
+func targetFilePaths(targets []string) []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, target := range targets {
+		if strings.TrimSpace(target) == "" {
+			continue
+		}
+		normalized := normalizePath(strings.TrimSpace(target))
+		if !seen[normalized] {
+			seen[normalized] = true
+			paths = append(paths, normalized)
+		}
+	}
+	return paths
+}
+
+// Narrows the context to the target files, the project files they import
+// and the essential files. The others are left out like with
+// MAX_CONTEXT_FILES, so the model still knows they exist. Reference-only
+// files are always kept. Returns the files unchanged when no target exists
+// yet, since there is nothing to scope around.
+func scopeToTargets(files []FileJSON, targets []string) ([]FileJSON, []string) {
+	if len(targets) == 0 {
+		return files, nil
+	}
+
+	byPath := make(map[string]FileJSON, len(files))
+	for _, file := range files {
+		if !file.ReadOnly {
+			byPath[contextFileKey(file)] = file
+		}
+	}
+	keep := map[string]bool{}
+	for _, target := range targetFilePaths(targets) {
+		file, ok := byPath[target]
+		if !ok {
+			continue
+		}
+		keep[target] = true
+		for _, imported := range localImports(target, file.Content, byPath) {
+			keep[imported] = true
+		}
+	}
+	if len(keep) == 0 {
+		return files, nil
+	}
+
+	kept := make([]FileJSON, 0, len(keep))
+	var omitted []string
+	for _, file := range files {
+		srcPath := contextFileKey(file)
+		if file.ReadOnly || keep[srcPath] || isEssentialFile(srcPath) {
+			kept = append(kept, file)
+			continue
+		}
+		omitted = append(omitted, srcPath)
+	}
+	return kept, omitted
+}
+
+// The project files a script imports with relative or tsconfig alias
+// specifiers, among the "src/..." paths in files
+func localImports(filePath, content string, files map[string]FileJSON) []string {
+	if !matchesFileType(filePath, scriptExtensions) {
+		return nil
+	}
+	tsconfig, baseDir := loadTsconfigPaths(filepath.Dir(projectRoot))
+
+	var imported []string
+	for _, source := range importSources(content) {
+		var bases []string
+		if strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../") {
+			bases = []string{path.Join(path.Dir(filePath), source)}
+		} else if candidates, ok := aliasCandidates(source, tsconfig, baseDir); ok {
+			for _, candidate := range candidates {
+				if rel, err := filepath.Rel(projectRoot, candidate); err == nil && !strings.HasPrefix(rel, "..") {
+					bases = append(bases, "src/"+filepath.ToSlash(rel))
+				}
+			}
+		}
+
+		for _, base := range bases {
+			tries := []string{base}
+			for _, ext := range importResolveExtensions {
+				tries = append(tries, base+ext, base+"/index"+ext)
+			}
+			for _, try := range tries {
+				if _, ok := files[try]; ok {
+					imported = append(imported, try)
+					break
+				}
+			}
+		}
+	}
+	return imported
+}
+
+// Steers the model towards the target files without forbidding new ones
+func targetFilesNote(targets []string) string {
+	paths := targetFilePaths(targets)
+	if len(paths) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\nFocus your changes on these files: %s. "+
+		"You may create new files when the change needs them, but avoid changing other existing files.", strings.Join(paths, ", "))
+}
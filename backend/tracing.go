@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracer for the edit pipeline. Until initTracing installs a provider it
+// hands out no-op spans, so tracing costs nothing when it isn't configured.
+var tracer = otel.Tracer("ai-sidepanel-backend")
+
+// Installs an OTLP/HTTP trace exporter when OTEL_EXPORTER_OTLP_ENDPOINT or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT is set. The exporter and resource read
+// the remaining standard OTEL_* variables (headers, service name, ...) themselves.
+// The returned function flushes the spans still buffered and stops the
+// exporter; it does nothing when tracing isn't enabled.
+func initTracing() (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if envBool("OTEL_SDK_DISABLED", false) {
+		return noop, nil
+	}
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return noop, nil
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return noop, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// Marks the span as failed when err is non-nil
+func recordSpanError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Attributes identifying the provider and model of an edit request
+func requestAttributes(req EditRequest) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("edit.provider", req.Provider),
+		attribute.String("edit.model", req.Model),
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+// Token counts reported by the provider, with an estimated cost in USD when
+// the model's price is known
+type TokenUsage struct {
+	PromptTokens     int      `json:"prompt_tokens"`
+	CompletionTokens int      `json:"completion_tokens"`
+	EstimatedCost    *float64 `json:"estimated_cost,omitempty"`
+}
+
+// Price of a model in USD per million tokens
+type modelPrice struct {
+	Prompt     float64 `json:"prompt"`
+	Completion float64 `json:"completion"`
+}
+
+// Built-in prices for the paid models offered by default. MODEL_PRICES_FILE
+// can point at a JSON object of the same shape to add or override entries.
+var defaultModelPrices = map[string]modelPrice{
+	"anthropic/claude-3.5-sonnet": {Prompt: 3, Completion: 15},
+	"openai/gpt-4o":               {Prompt: 2.5, Completion: 10},
+	"openai/gpt-4o-mini":          {Prompt: 0.15, Completion: 0.6},
+	"google/gemini-pro-1.5":       {Prompt: 1.25, Completion: 5},
+	"qwen/qwen-2.5-72b-instruct":  {Prompt: 0.35, Completion: 0.4},
+}
+
+// Returns the price table, merging MODEL_PRICES_FILE over the defaults
+func modelPrices() map[string]modelPrice {
+	prices := make(map[string]modelPrice, len(defaultModelPrices))
+	for model, price := range defaultModelPrices {
+		prices[model] = price
+	}
+
+	path := os.Getenv("MODEL_PRICES_FILE")
+	if path == "" {
+		return prices
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read MODEL_PRICES_FILE: %v", err)
+		return prices
+	}
+	var custom map[string]modelPrice
+	if err := json.Unmarshal(data, &custom); err != nil {
+		log.Printf("Failed to parse MODEL_PRICES_FILE: %v", err)
+		return prices
+	}
+	for model, price := range custom {
+		prices[model] = price
+	}
+	return prices
+}
+
+// Fills in EstimatedCost when the price of the model is known. Local Ollama
+// models and OpenRouter ":free" models cost nothing.
+func (u *TokenUsage) estimateCost(provider, model string) {
+	if u == nil {
+		return
+	}
+
+	var cost float64
+	switch {
+	case provider == "ollama" || strings.HasSuffix(model, ":free"):
+		cost = 0
+	default:
+		price, ok := modelPrices()[model]
+		if !ok {
+			return
+		}
+		cost = (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1_000_000
+	}
+	u.EstimatedCost = &cost
+}
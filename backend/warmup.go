@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Preloads an Ollama model so the next edit doesn't pay the cold-start cost
+func handleWarmup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w, "POST")
+		return
+	}
+
+	model := resolveModelAlias(r.URL.Query().Get("model"))
+	if model == "" {
+		writeError(w, http.StatusBadRequest, errCodeInvalidRequest, "model query parameter is required", nil)
+		return
+	}
+
+	started := time.Now()
+	if err := warmOllamaModel(model, envDuration("WARMUP_TIMEOUT", 2*time.Minute)); err != nil {
+		writeError(w, http.StatusBadGateway, errCodeUpstream, err.Error(), map[string]string{"provider": "ollama", "model": model})
+		return
+	}
+
+	response := map[string]interface{}{
+		"status":     "loaded",
+		"model":      model,
+		"elapsed_ms": time.Since(started).Milliseconds(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Asks Ollama to load the model into memory. A generate request without a
+// prompt only loads the model and returns once it is ready.
+func warmOllamaModel(model string, timeout time.Duration) error {
+	jsonData, err := json.Marshal(map[string]interface{}{"model": model})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", "http://localhost:11434/api/generate", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("timed out after %s waiting for Ollama to load %s", timeout, model)
+		}
+		return fmt.Errorf("failed to connect to Ollama (make sure it's running on localhost:11434): %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Ollama API error %d: %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
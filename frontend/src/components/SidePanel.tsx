@@ -68,7 +68,16 @@ export default function SidePanel() {
       
       if (!res.ok) {
         const errorText = await res.text();
-        throw new Error(`HTTP ${res.status}: ${errorText}`);
+        let message = errorText;
+        try {
+          const body = JSON.parse(errorText);
+          if (body?.error?.message) {
+            message = `${body.error.code}: ${body.error.message}`;
+          }
+        } catch {
+          // Not a JSON error body, show it as-is
+        }
+        throw new Error(`HTTP ${res.status}: ${message}`);
       }
       
       const data = await res.json();